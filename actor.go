@@ -0,0 +1,142 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+var ErrEngineClosed = errors.New("engine is closed")
+
+// commandQueueSize bounds how many commands may be waiting, callers block once it is full
+const commandQueueSize = 64
+
+// commandRequest is a single frame waiting for the command goroutine to write it
+type commandRequest struct {
+	// cmd is nil for custom frames the protocol package does not understand
+	cmd      protocol.Command
+	frame    []byte
+	priority Priority
+	// key is what the command coalesces on, empty if it never does
+	key string
+	// guarded requests are refused if there has been an emergency stop since stops was read
+	guarded bool
+	stops   uint64
+	done    chan error
+	// the watchdog stopping the train, let through while it has guarded motion halted
+	watchdog bool
+}
+
+// runCommands is the only goroutine that ever writes to the transport or changes the
+// engine state, so frames from concurrent callers can never interleave
+func (a *TrainEngine) runCommands() {
+	for {
+		pending, emergency := a.scheduler.hasPending()
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if pending && !emergency {
+			// emergencies skip the rate limit, everything else waits its turn
+			if delay := a.limiter.delay(time.Now()); delay > 0 {
+				timer = time.NewTimer(delay)
+				wait = timer.C
+				pending = false
+			}
+		}
+
+		if pending {
+			request := a.scheduler.next()
+			a.limiter.take(time.Now())
+			request.done <- a.execute(request)
+			continue
+		}
+
+		select {
+		case <-a.ctx.Done():
+			a.scheduler.flushAll(ErrEngineClosed)
+			return
+		case <-a.scheduler.wake:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (a *TrainEngine) execute(request *commandRequest) error {
+	if request.cmd != nil && request.priority != PRIORITY_EMERGENCY {
+		stopFirst, err := a.checkInterlocks(request.cmd)
+		if err != nil {
+			a.logger.Printf("Refusing '% x': %v", request.frame, err)
+			return err
+		}
+		if stopFirst {
+			a.logger.Println("Stopping the train before changing direction")
+			stop := protocol.SetSpeed{Speed: 0}
+			frame, err := protocol.Marshal(stop)
+			if err != nil {
+				return err
+			}
+			err = a.execute(&commandRequest{cmd: stop, frame: frame, priority: PRIORITY_MOTION})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := a.transport.WriteFrame(request.frame)
+	if err != nil {
+		a.limiter.failed.Add(1)
+		return err
+	}
+	a.limiter.written.Add(1)
+
+	if request.cmd != nil {
+		a.stateLock.Lock()
+		events := applyCommand(a.state, request.cmd)
+		a.state.LastCommand = time.Now()
+		a.stateLock.Unlock()
+		a.publish(events...)
+	}
+	return nil
+}
+
+// submit queues a request for the command goroutine and waits for it to be written
+func (a *TrainEngine) submit(request *commandRequest) error {
+	return a.submitContext(context.Background(), request)
+}
+
+// submitContext is submit, giving up waiting when ctx is done. A request that was already
+// queued by then is still written.
+func (a *TrainEngine) submitContext(ctx context.Context, request *commandRequest) error {
+	// select picks at random, so never queue anything once ctx is done
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	request.done = make(chan error, 1)
+	if request.priority != PRIORITY_EMERGENCY {
+		select {
+		case a.scheduler.slots <- struct{}{}:
+		case <-a.ctx.Done():
+			return ErrEngineClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := a.scheduler.push(request)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-request.done:
+		return err
+	case <-a.ctx.Done():
+		return ErrEngineClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
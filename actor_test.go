@@ -0,0 +1,190 @@
+package lionchief
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// newTestEngine drives a MemoryTransport without a rate limit or logging, with the frames
+// written while connecting already forgotten
+func newTestEngine(t *testing.T, opts ...Option) (*TrainEngine, *MemoryTransport) {
+	t.Helper()
+	transport := NewMemoryTransport()
+	engine := newTestEngineWith(t, transport, opts...)
+	transport.Reset()
+	return engine, transport
+}
+
+func newTestEngineWith(t *testing.T, transport Transport, opts ...Option) *TrainEngine {
+	t.Helper()
+	opts = append([]Option{WithLogger(log.New(io.Discard, "", 0)), WithRateLimit(RateLimit{})}, opts...)
+	engine, err := NewEngineWithTransport(transport, opts...)
+	if err != nil {
+		t.Fatalf("NewEngineWithTransport failed: %v", err)
+	}
+	t.Cleanup(func() {
+		engine.Disconnect()
+	})
+	return engine
+}
+
+func mustMarshal(t *testing.T, cmd protocol.Command) []byte {
+	t.Helper()
+	frame, err := protocol.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("Marshal(%#v) failed: %v", cmd, err)
+	}
+	return frame
+}
+
+// assertFrames checks exactly the frames of expected were written, in order
+func assertFrames(t *testing.T, transport *MemoryTransport, expected ...protocol.Command) {
+	t.Helper()
+	frames := transport.Frames()
+	ok := len(frames) == len(expected)
+	for i := 0; ok && i < len(frames); i++ {
+		ok = bytes.Equal(frames[i], mustMarshal(t, expected[i]))
+	}
+	if !ok {
+		t.Fatalf("frames written:\n%s\nexpected:\n%s", describeFrames(frames), describeCommands(t, expected))
+	}
+}
+
+func describeFrames(frames [][]byte) string {
+	var text bytes.Buffer
+	for _, frame := range frames {
+		fmt.Fprintf(&text, "\t% x\n", frame)
+	}
+	return text.String()
+}
+
+func describeCommands(t *testing.T, cmds []protocol.Command) string {
+	var text bytes.Buffer
+	for _, cmd := range cmds {
+		fmt.Fprintf(&text, "\t% x (%#v)\n", mustMarshal(t, cmd), cmd)
+	}
+	return text.String()
+}
+
+// holdCommands stops the command goroutine writing anything but emergencies until the
+// returned func is called, so tests can line commands up behind it
+func holdCommands(engine *TrainEngine) func() {
+	engine.limiter.lock.Lock()
+	engine.limiter.tokens = 0
+	engine.limiter.lock.Unlock()
+	engine.SetRateLimit(RateLimit{FramesPerSecond: 1e-9, Burst: 1})
+	return func() {
+		engine.SetRateLimit(RateLimit{})
+	}
+}
+
+func pendingCount(engine *TrainEngine) int {
+	engine.scheduler.lock.Lock()
+	defer engine.scheduler.lock.Unlock()
+	count := 0
+	for _, pending := range engine.scheduler.pending {
+		count += len(pending)
+	}
+	return count
+}
+
+// waitFor polls condition until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queued is a command running in the background
+type queued struct {
+	done chan error
+}
+
+// queue runs command in the background, waiting until taken says the scheduler has it
+func queue(t *testing.T, engine *TrainEngine, command func() error, taken func() bool) queued {
+	t.Helper()
+	result := queued{done: make(chan error, 1)}
+	go func() {
+		result.done <- command()
+	}()
+	waitFor(t, "the command to be queued", taken)
+	return result
+}
+
+func (a queued) wait(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-a.done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a queued command")
+		return nil
+	}
+}
+
+func TestConcurrentCallers(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	writtenBefore := engine.Stats().Written
+
+	const callers = 16
+	const calls = 25
+	var wait sync.WaitGroup
+	errs := make(chan error, callers*calls*3)
+	for caller := 0; caller < callers; caller++ {
+		wait.Add(1)
+		go func(caller int) {
+			defer wait.Done()
+			for call := 0; call < calls; call++ {
+				errs <- engine.SetSpeed((caller + call) % 20)
+				errs <- engine.SetHorn(call%2 == 0)
+				errs <- engine.SetHornVolume(call % 10)
+			}
+		}(caller)
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent command failed: %v", err)
+		}
+	}
+
+	frames := transport.Frames()
+	horns := 0
+	lastSpeed := -1
+	for _, frame := range frames {
+		cmd, err := protocol.Unmarshal(frame)
+		if err != nil {
+			t.Fatalf("garbled frame '% x' written: %v", frame, err)
+		}
+		switch c := cmd.(type) {
+		case protocol.Horn:
+			horns++
+		case protocol.SetSpeed:
+			lastSpeed = int(c.Speed)
+		}
+	}
+	// momentary commands are never coalesced away
+	if horns != callers*calls {
+		t.Errorf("'%d' horn frames written, expected '%d'", horns, callers*calls)
+	}
+	stats := engine.Stats()
+	if stats.Written-writtenBefore != uint64(len(frames)) {
+		t.Errorf("stats count '%d' frames written, transport has '%d'", stats.Written-writtenBefore, len(frames))
+	}
+	if engine.GetSpeed() != lastSpeed {
+		t.Errorf("state has speed '%d', last speed written was '%d'", engine.GetSpeed(), lastSpeed)
+	}
+}
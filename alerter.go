@@ -0,0 +1,294 @@
+package lionchief
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrAlerterRunning = errors.New("alerter is already running")
+
+// AlerterState is where the alerter is in its cycle.
+type AlerterState int
+
+const (
+	// The train is stopped, nothing to watch
+	ALERTERSTATE_IDLE AlerterState = iota
+	// The train is moving and the operator has acknowledged recently
+	ALERTERSTATE_WATCHING
+	// The operator missed an acknowledgement, the warning is sounding
+	ALERTERSTATE_WARNING
+	// The warning was ignored too, the train is being brought to a stop
+	ALERTERSTATE_STOPPING
+)
+
+func (a AlerterState) String() string {
+	switch a {
+	case ALERTERSTATE_IDLE:
+		return "Idle"
+	case ALERTERSTATE_WATCHING:
+		return "Watching"
+	case ALERTERSTATE_WARNING:
+		return "Warning"
+	case ALERTERSTATE_STOPPING:
+		return "Stopping"
+	}
+	return "Unknown"
+}
+
+// AlerterConfig sets up an Alerter. While the train moves the operator has to acknowledge at
+// least every Interval. Missing one sounds Warning (horn or bell) for WarningPeriod, and if that
+// is not acknowledged either the train is brought to a controlled stop.
+type AlerterConfig struct {
+	Interval      time.Duration
+	WarningPeriod time.Duration
+	Warning       SoundType
+	// Clock to time everything by, SystemClock when nil
+	Clock Clock
+}
+
+var DefaultAlerterConfig = AlerterConfig{
+	Interval:      60 * time.Second,
+	WarningPeriod: 10 * time.Second,
+	Warning:       SOUNDTYPE_BELL,
+}
+
+// Alerter is the operator vigilance (dead-man) device for a throttle.
+type Alerter struct {
+	simulator *TrainSimulator
+	config    AlerterConfig
+
+	lock     sync.Mutex
+	state    AlerterState
+	handlers []func(from AlerterState, to AlerterState)
+	running  bool
+	ack      chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+
+	// whether the warning holds its sound, only touched by the alerter's goroutine
+	warning bool
+}
+
+func NewAlerter(simulator *TrainSimulator, config AlerterConfig) (*Alerter, error) {
+	if config.Interval <= 0 || config.WarningPeriod < 0 {
+		return nil, fmt.Errorf("invalid alerter timing, interval '%v' must be positive and warning period '%v' not negative", config.Interval, config.WarningPeriod)
+	}
+	if config.Warning != SOUNDTYPE_HORN && config.Warning != SOUNDTYPE_BELL {
+		return nil, fmt.Errorf("invalid alerter warning '%s', must be the horn or the bell", soundTypeName(config.Warning))
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+	return &Alerter{
+		simulator: simulator,
+		config:    config,
+	}, nil
+}
+
+// NewAlerter makes an alerter with the settings this train was registered with, or
+// DefaultAlerterConfig if it has none.
+func (a *TrainSimulator) NewAlerter() (*Alerter, error) {
+	config := DefaultAlerterConfig
+	if a.registry != nil {
+		if registered, ok := a.registry.Lookup(a.registered); ok && registered.Alerter != nil {
+			config = registered.Alerter.config()
+		}
+	}
+	return NewAlerter(a, config)
+}
+
+func (a *Alerter) State() AlerterState {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.state
+}
+
+// OnStateChange registers a handler called (on the alerter's goroutine) on every state change,
+// e.g. to flash a light on the throttle.
+func (a *Alerter) OnStateChange(handler func(from AlerterState, to AlerterState)) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.handlers = append(a.handlers, handler)
+}
+
+// Acknowledge resets the alerter, silencing the warning if it is sounding. Once the train is
+// being stopped it is too late, the stop carries on.
+func (a *Alerter) Acknowledge() {
+	a.lock.Lock()
+	ack := a.ack
+	a.lock.Unlock()
+	if ack == nil {
+		return
+	}
+	select {
+	case ack <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Alerter) Start() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.running {
+		return ErrAlerterRunning
+	}
+	a.running = true
+	a.ack = make(chan struct{}, 1)
+	a.stop = make(chan struct{})
+	a.done = make(chan struct{})
+	go a.run(a.ack, a.stop, a.done)
+	return nil
+}
+
+// Stop stops watching, silencing the warning if it is sounding. It waits for a stop in
+// progress to finish.
+func (a *Alerter) Stop() {
+	a.lock.Lock()
+	if !a.running {
+		a.lock.Unlock()
+		return
+	}
+	a.running = false
+	stop, done := a.stop, a.done
+	a.ack = nil
+	a.lock.Unlock()
+
+	close(stop)
+	<-done
+}
+
+func (a *Alerter) run(ack chan struct{}, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	events, unsubscribe := a.simulator.Subscribe()
+	defer unsubscribe()
+
+	clock := a.config.Clock
+	var timer Timer
+	var stopped chan error
+	arm := func(d time.Duration) {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = nil
+		if d > 0 {
+			timer = clock.NewTimer(d)
+		}
+	}
+	// a nil timer never fires
+	expired := func() <-chan time.Time {
+		if timer == nil {
+			return nil
+		}
+		return timer.C()
+	}
+	watch := func() {
+		a.setState(ALERTERSTATE_WATCHING)
+		arm(a.config.Interval)
+	}
+	idle := func() {
+		if a.State() == ALERTERSTATE_WARNING {
+			a.warn(false)
+		}
+		a.setState(ALERTERSTATE_IDLE)
+		arm(0)
+	}
+
+	if a.simulator.engine.GetSpeed() > 0 {
+		watch()
+	}
+	for {
+		select {
+		case <-stop:
+			if a.State() == ALERTERSTATE_STOPPING {
+				<-stopped
+			}
+			idle()
+			return
+		case event := <-events:
+			if event.Type != EVENT_SPEED || a.State() == ALERTERSTATE_STOPPING {
+				continue
+			}
+			moving := event.After.(int) > 0
+			if moving && a.State() == ALERTERSTATE_IDLE {
+				watch()
+			} else if !moving {
+				idle()
+			}
+		case <-ack:
+			switch a.State() {
+			case ALERTERSTATE_WARNING:
+				a.warn(false)
+				watch()
+			case ALERTERSTATE_WATCHING:
+				watch()
+			}
+		case <-expired():
+			timer = nil
+			switch {
+			case a.simulator.engine.GetSpeed() == 0:
+				// missed the train stopping, nothing to watch
+				idle()
+			case a.State() == ALERTERSTATE_WATCHING:
+				a.setState(ALERTERSTATE_WARNING)
+				a.warn(true)
+				timer = clock.NewTimer(a.config.WarningPeriod)
+			case a.State() == ALERTERSTATE_WARNING:
+				a.warn(false)
+				a.setState(ALERTERSTATE_STOPPING)
+				stopped = make(chan error, 1)
+				go func() {
+					stopped <- a.simulator.AdjustSpeedTo(0)
+				}()
+			}
+		case err := <-stopped:
+			stopped = nil
+			if err != nil {
+				a.simulator.engine.logger.Printf("Alerter could not stop the train: %v", err)
+				err = a.simulator.EmergencyStop()
+				if err != nil {
+					a.simulator.engine.logger.Printf("Alerter could not emergency stop the train: %v", err)
+				}
+			}
+			idle()
+		}
+	}
+}
+
+// warn holds or releases the warning sound, sharing it with any other effect sounding it
+// at the time so neither cuts the other short.
+func (a *Alerter) warn(on bool) {
+	if on == a.warning {
+		return
+	}
+	var err error
+	if on {
+		err = a.simulator.engine.holdSound(a.config.Warning)
+	} else {
+		err = a.simulator.engine.releaseSound(a.config.Warning)
+	}
+	if err != nil {
+		a.simulator.engine.logger.Printf("Alerter warning failed: %v", err)
+	}
+	// a failed hold is given back by holdSound, and a failed release has let go regardless
+	a.warning = on && err == nil
+}
+
+func (a *Alerter) setState(state AlerterState) {
+	a.lock.Lock()
+	from := a.state
+	if from == state {
+		a.lock.Unlock()
+		return
+	}
+	a.state = state
+	handlers := make([]func(AlerterState, AlerterState), len(a.handlers))
+	copy(handlers, a.handlers)
+	a.lock.Unlock()
+
+	a.simulator.engine.logger.Printf("Alerter %v -> %v", from, state)
+	for _, handler := range handlers {
+		handler(from, state)
+	}
+}
@@ -0,0 +1,100 @@
+package lionchief
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// startTestAlerter runs an alerter on a virtual clock with the train already moving
+func startTestAlerter(t *testing.T) (*Alerter, *VirtualClock, *MemoryTransport) {
+	t.Helper()
+	simulator, transport := newTestSimulator(t)
+	clock := NewVirtualClock(time.Unix(0, 0))
+	alerter, err := NewAlerter(simulator, AlerterConfig{
+		Interval:      time.Minute,
+		WarningPeriod: 10 * time.Second,
+		Warning:       SOUNDTYPE_BELL,
+		Clock:         clock,
+	})
+	if err != nil {
+		t.Fatalf("NewAlerter failed: %v", err)
+	}
+	err = simulator.engine.SetSpeed(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = alerter.Start()
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(alerter.Stop)
+
+	// the interval is running
+	clock.BlockUntil(1)
+	transport.Reset()
+	return alerter, clock, transport
+}
+
+func waitForAlerterState(t *testing.T, alerter *Alerter, state AlerterState) {
+	t.Helper()
+	waitFor(t, "the alerter to be "+state.String(), func() bool { return alerter.State() == state })
+}
+
+func TestAlerterStopsTheTrain(t *testing.T) {
+	alerter, clock, transport := startTestAlerter(t)
+
+	// an acknowledgement starts the interval over
+	clock.Advance(50 * time.Second)
+	alerter.Acknowledge()
+	waitFor(t, "the interval to start over", func() bool {
+		clock.lock.Lock()
+		defer clock.lock.Unlock()
+		return len(clock.timers) == 1 && clock.timers[0].at.Equal(clock.now.Add(time.Minute))
+	})
+	clock.Advance(50 * time.Second)
+	if alerter.State() != ALERTERSTATE_WATCHING {
+		t.Fatalf("alerter is '%v' within the interval of an acknowledgement", alerter.State())
+	}
+	assertFrames(t, transport)
+
+	clock.Advance(10 * time.Second)
+	waitForAlerterState(t, alerter, ALERTERSTATE_WARNING)
+	clock.BlockUntil(1)
+	assertFrames(t, transport, protocol.Bell{On: true})
+
+	clock.Advance(10 * time.Second)
+	waitForAlerterState(t, alerter, ALERTERSTATE_IDLE)
+	assertFrames(t, transport,
+		protocol.Bell{On: true},
+		protocol.Bell{On: false},
+		protocol.SetSpeed{Speed: 2},
+		protocol.SetSpeed{Speed: 1},
+		protocol.SetSpeed{Speed: 0},
+	)
+}
+
+func TestAlerterSharesTheWarningSound(t *testing.T) {
+	alerter, clock, transport := startTestAlerter(t)
+
+	clock.Advance(time.Minute)
+	waitForAlerterState(t, alerter, ALERTERSTATE_WARNING)
+	clock.BlockUntil(1)
+
+	// ringing the bell as well, the acknowledgement must not cut it off
+	bell, err := alerter.simulator.HoldBell(context.Background())
+	if err != nil {
+		t.Fatalf("HoldBell failed: %v", err)
+	}
+	alerter.Acknowledge()
+	waitForAlerterState(t, alerter, ALERTERSTATE_WATCHING)
+	assertFrames(t, transport, protocol.Bell{On: true})
+
+	err = bell.Stop()
+	if err != nil {
+		t.Fatalf("stopping the bell failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Bell{On: true}, protocol.Bell{On: false})
+}
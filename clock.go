@@ -0,0 +1,136 @@
+package lionchief
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for anything that waits, so it can be driven by a VirtualClock
+// instead of the wall clock.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+// Timer fires once on C, unless stopped first.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// SystemClock is the real wall clock.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                 { return time.Now() }
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+func (systemClock) Sleep(d time.Duration)          { time.Sleep(d) }
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func (a systemTimer) C() <-chan time.Time { return a.timer.C }
+func (a systemTimer) Stop() bool          { return a.timer.Stop() }
+
+// VirtualClock only moves when told to with Advance, which makes timing dependent code
+// deterministic and instant to run.
+type VirtualClock struct {
+	lock    sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	timers  []*virtualTimer
+}
+
+type virtualTimer struct {
+	clock *VirtualClock
+	at    time.Time
+	fired chan time.Time
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	clock := VirtualClock{now: start}
+	clock.changed = sync.NewCond(&clock.lock)
+	return &clock
+}
+
+func (a *VirtualClock) Now() time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.now
+}
+
+func (a *VirtualClock) NewTimer(d time.Duration) Timer {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	timer := &virtualTimer{clock: a, at: a.now.Add(d), fired: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.fired <- a.now
+		return timer
+	}
+	a.timers = append(a.timers, timer)
+	a.changed.Broadcast()
+	return timer
+}
+
+func (a *VirtualClock) Sleep(d time.Duration) {
+	<-a.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d, firing every timer that falls due on the way in order.
+func (a *VirtualClock) Advance(d time.Duration) {
+	a.lock.Lock()
+	a.now = a.now.Add(d)
+	sort.SliceStable(a.timers, func(i, j int) bool {
+		return a.timers[i].at.Before(a.timers[j].at)
+	})
+	due := 0
+	for due < len(a.timers) && !a.timers[due].at.After(a.now) {
+		due++
+	}
+	fired := a.timers[:due]
+	a.timers = append([]*virtualTimer(nil), a.timers[due:]...)
+	a.changed.Broadcast()
+	a.lock.Unlock()
+
+	for _, timer := range fired {
+		timer.fired <- timer.at
+	}
+}
+
+// Waiters is how many timers are waiting on the clock.
+func (a *VirtualClock) Waiters() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.timers)
+}
+
+// BlockUntil waits for n timers to be waiting on the clock, so whatever it drives has got
+// round to waiting before the caller Advances it.
+func (a *VirtualClock) BlockUntil(n int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for len(a.timers) != n {
+		a.changed.Wait()
+	}
+}
+
+func (a *virtualTimer) C() <-chan time.Time {
+	return a.fired
+}
+
+func (a *virtualTimer) Stop() bool {
+	a.clock.lock.Lock()
+	defer a.clock.lock.Unlock()
+	for i, timer := range a.clock.timers {
+		if timer == a {
+			a.clock.timers = append(a.clock.timers[:i], a.clock.timers[i+1:]...)
+			a.clock.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
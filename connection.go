@@ -0,0 +1,218 @@
+package lionchief
+
+import (
+	"fmt"
+	"time"
+)
+
+type ConnectionState int
+
+const (
+	CONNECTIONSTATE_CONNECTING ConnectionState = iota
+	CONNECTIONSTATE_CONNECTED
+	CONNECTIONSTATE_RECONNECTING
+	CONNECTIONSTATE_DISCONNECTED
+	CONNECTIONSTATE_FAILED
+)
+
+func (a ConnectionState) String() string {
+	switch a {
+	case CONNECTIONSTATE_CONNECTING:
+		return "Connecting"
+	case CONNECTIONSTATE_CONNECTED:
+		return "Connected"
+	case CONNECTIONSTATE_RECONNECTING:
+		return "Reconnecting"
+	case CONNECTIONSTATE_DISCONNECTED:
+		return "Disconnected"
+	case CONNECTIONSTATE_FAILED:
+		return "Failed"
+	}
+	return "Unknown"
+}
+
+func (a ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *ConnectionState) UnmarshalText(text []byte) error {
+	for state := CONNECTIONSTATE_CONNECTING; state <= CONNECTIONSTATE_FAILED; state++ {
+		if state.String() == string(text) {
+			*a = state
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid connection state '%s'", text)
+}
+
+// ReconnectPolicy controls how hard the engine tries to get a dropped link back.
+// Each failed attempt waits InitialBackoff, growing by Multiplier up to MaxBackoff.
+// A MaxAttempts of 0 retries forever, a negative one never reconnects.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	MaxAttempts    int
+}
+
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	MaxAttempts:    0,
+}
+
+// backoff is how long to wait after the given (1 based) failed attempt
+func (a ReconnectPolicy) backoff(attempt int) time.Duration {
+	wait := float64(a.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		wait *= a.Multiplier
+		if time.Duration(wait) >= a.MaxBackoff {
+			return a.MaxBackoff
+		}
+	}
+	return time.Duration(wait)
+}
+
+// ConnectionChange describes a single transition of the engine's connection state.
+type ConnectionChange struct {
+	From    ConnectionState
+	To      ConnectionState
+	Attempt int
+	Err     error
+	Time    time.Time
+}
+
+func (a *TrainEngine) ConnectionState() ConnectionState {
+	a.connLock.Lock()
+	defer a.connLock.Unlock()
+	return a.connState
+}
+
+// OnConnectionChange registers a handler called (on the engine's connection goroutine) for
+// every connection state transition.
+func (a *TrainEngine) OnConnectionChange(handler func(ConnectionChange)) {
+	a.connLock.Lock()
+	defer a.connLock.Unlock()
+	a.connHandlers = append(a.connHandlers, handler)
+}
+
+func (a *TrainEngine) SetReconnectPolicy(policy ReconnectPolicy) {
+	a.connLock.Lock()
+	defer a.connLock.Unlock()
+	a.reconnectPolicy = policy
+}
+
+func (a *TrainEngine) setConnectionState(state ConnectionState, attempt int, err error) {
+	a.connLock.Lock()
+	change := ConnectionChange{
+		From:    a.connState,
+		To:      state,
+		Attempt: attempt,
+		Err:     err,
+		Time:    time.Now(),
+	}
+	if change.From == change.To {
+		a.connLock.Unlock()
+		return
+	}
+	a.connState = state
+	handlers := make([]func(ConnectionChange), len(a.connHandlers))
+	copy(handlers, a.connHandlers)
+	a.connLock.Unlock()
+
+	a.logger.Printf("Connection %v -> %v", change.From, change.To)
+	a.publish(Event{Type: EVENT_CONNECTION, Before: change.From, After: change.To, Time: change.Time})
+	for _, handler := range handlers {
+		handler(change)
+	}
+}
+
+// followLink reads the transport's link events as they arrive, so Shutdown hears the train
+// hang up even while watchConnection is busy reconnecting. Only the latest event is kept
+// for watchConnection.
+func (a *TrainEngine) followLink(events <-chan bool, links chan bool) {
+	defer close(links)
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case connected, ok := <-events:
+			if !ok {
+				return
+			}
+			if !connected && a.shuttingDown.Load() {
+				// the train hung up on us as asked, nothing to reconnect
+				close(a.linkClosed)
+				return
+			}
+			select {
+			case <-links:
+			default:
+			}
+			links <- connected
+		}
+	}
+}
+
+// watchConnection reconnects when the link drops, until the engine is shut down
+func (a *TrainEngine) watchConnection(links <-chan bool) {
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case connected, ok := <-links:
+			if !ok {
+				return
+			}
+			if connected || a.ConnectionState() != CONNECTIONSTATE_CONNECTED {
+				continue
+			}
+			a.logger.Println("Train disconnected.")
+			a.reconnect()
+		}
+	}
+}
+
+// reconnect retries the transport with backoff until it succeeds, the policy gives up or
+// the engine is shut down
+func (a *TrainEngine) reconnect() {
+	a.connLock.Lock()
+	policy := a.reconnectPolicy
+	a.connLock.Unlock()
+
+	if policy.MaxAttempts < 0 {
+		a.setConnectionState(CONNECTIONSTATE_DISCONNECTED, 0, nil)
+		return
+	}
+
+	a.setConnectionState(CONNECTIONSTATE_RECONNECTING, 0, nil)
+	var lastErr error
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		a.logger.Printf("Attempting Reconnect '%d'", attempt)
+		err := a.transport.Reconnect(a.ctx)
+		if err == nil {
+			// the train forgot everything, so tell it again what we want
+			err = a.restoreState()
+			if err != nil {
+				a.logger.Printf("Restoring train state failed: %v", err)
+			}
+			a.setConnectionState(CONNECTIONSTATE_CONNECTED, attempt, nil)
+			return
+		}
+		if a.ctx.Err() != nil {
+			return
+		}
+		a.logger.Printf("Reconnect '%d' failed: %v", attempt, err)
+		lastErr = err
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-a.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	a.setConnectionState(CONNECTIONSTATE_FAILED, policy.MaxAttempts, lastErr)
+}
@@ -1,51 +1,59 @@
 package lionchief
 
-// Train Commands in HEX
-// Set horn volume/pitch: 44 01 <00-0f> <fe-02>
-// Set bell volume/pitch: 44 02 <00-0f> <fe-02>
-// Set speech volume/pitch: 44 03 <00-0f> <fe-02>
-// Set engine volume/pitch: 44 04 <00-0f> <fe-02>
-// Set speed : 45 <00-1f>
-// Forward : 46 01
-// Reverse : 46 02
-// Bell start: 47 01
-// Bell stop : 47 00
-// Horn start: 48 01
-// Horn stop : 48 00
-// Disconnect: 4b 0 0
-// Set overall volume: 4c <00-07>
-// Speech : 4d XX 00
-// Set lights off: 51 00
-// Set lights on: 51 01
+// The wire format for every command lives in the protocol package.
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"slices"
+	"sync"
+	"sync/atomic"
 
+	"github.com/jasper-186/lionchief/protocol"
 	"tinygo.org/x/bluetooth"
 )
 
-type TrainState struct {
-	Speed        int
-	Reverse      bool
-	Light        bool
-	Volume       int
-	VolumeHorn   int
-	VolumeEngine int
-	VolumeBell   int
-	VolumeSpeech int
-}
-
 type TrainEngine struct {
-	adapter             *bluetooth.Adapter
-	disconnected        *chan bluetooth.Device
-	device              *bluetooth.Device
-	writeService        *bluetooth.DeviceService
-	writeCharacteristic *bluetooth.DeviceCharacteristic
-	state               *TrainState
-	reconnect           bool
+	transport     Transport
+	logger        *log.Logger
+	state         *TrainState
+	reported      TrainState
+	reportedLock  sync.Mutex
+	notifications chan Notification
+	info          *EngineInfo
+	profile       atomic.Pointer[EngineProfile]
+	stateLock     sync.RWMutex
+	scheduler     *commandScheduler
+	eventLock     sync.Mutex
+	subscribers   map[chan Event]struct{}
+	limiter       *rateLimiter
+
+	ctx             context.Context
+	cancel          context.CancelFunc
+	connLock        sync.Mutex
+	connState       ConnectionState
+	connHandlers    []func(ConnectionChange)
+	reconnectPolicy ReconnectPolicy
+	restorePolicy   RestorePolicy
+	shuttingDown    atomic.Bool
+	linkClosed      chan struct{}
+
+	watchdogLock sync.Mutex
+	watchdog     *watchdog
+
+	soundLock sync.Mutex
+	// effects holding each sound on, it only goes off once the last lets go
+	sounding map[SoundType]int
+
+	interlockLock sync.RWMutex
+	interlocks    Interlocks
+	rules         []InterlockRule
+
+	// what the watchdog, its ramp down and its alert wait by
+	clock Clock
 }
 
 func must(action string, err error) {
@@ -54,61 +62,44 @@ func must(action string, err error) {
 	}
 }
 
-func calculateChecksum(cmdBuffer []byte) byte {
-	sumValue := 0
-	for _, value := range cmdBuffer {
-		sumValue = (int(value) + sumValue)
-	}
-	return byte(uint(sumValue))
+func NewEngineDefaultBluetoothAdapter(trainAddress bluetooth.Address) (*TrainEngine, error) {
+	return NewEngineContext(context.Background(), trainAddress)
 }
 
-func NewEngineDefaultBluetoothAdapter(trainAddress bluetooth.Address) (*TrainEngine, error) {
-	return NewEngine(trainAddress, bluetooth.DefaultAdapter)
+func NewBluetoothEngine(trainAddress bluetooth.Address, adapter *bluetooth.Adapter) (*TrainEngine, error) {
+	return NewEngineContext(context.Background(), trainAddress, WithAdapter(adapter))
 }
 
+// NewEngine connects to the train at trainAddress using adapter.
+//
+// Deprecated: use NewBluetoothEngine, or NewEngineWithTransport for other transports.
 func NewEngine(trainAddress bluetooth.Address, adapter *bluetooth.Adapter) (*TrainEngine, error) {
+	return NewBluetoothEngine(trainAddress, adapter)
+}
 
-	connectionParams := bluetooth.ConnectionParams{}
-
-	log.Println("Enabling Adapter")
-	adapter.Enable()
-
-	disconnected := make(chan bluetooth.Device)
-	adapter.SetConnectHandler(func(device bluetooth.Device, connected bool) {
-		log.Println("Connection handler")
-		if !connected {
-			disconnected <- device
-		}
-	})
-
-	device, err := adapter.Connect(trainAddress, connectionParams)
-	must(fmt.Sprintf("Connecting to '%v'\n", trainAddress.MAC.String()), err)
-
-	devicesServices, err := device.DiscoverServices([]bluetooth.UUID{ReadWriteService})
-	must("Discovering Services", err)
-
-	log.Printf("Found '%v' services", len(devicesServices))
-	if len(devicesServices) < 1 {
-		return nil, errors.New("failed to find read/write service")
-	}
-
-	log.Println("Discovering Characteristics")
-	characteristics, err := devicesServices[0].DiscoverCharacteristics([]bluetooth.UUID{WriteCharacteristic})
+// NewEngineContext connects to the train at trainAddress over BLE. Enabling the adapter, connecting
+// and discovery all give up when ctx is done; ctx does not bound the lifetime of the engine.
+func NewEngineContext(ctx context.Context, trainAddress bluetooth.Address, opts ...Option) (*TrainEngine, error) {
+	transport, err := NewBluetoothTransportContext(ctx, trainAddress, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Found '%v' characteristics", len(characteristics))
-	if len(characteristics) < 1 {
-		return nil, errors.New("write characteristic not found")
+	train, err := NewEngineWithTransport(transport, opts...)
+	if err != nil {
+		transport.Close()
+		return nil, err
 	}
+	return train, nil
+}
 
+// NewEngineWithTransport drives a train through the given transport, resetting it to the default
+// state before returning unless WithResetOnConnect(false) is given.
+func NewEngineWithTransport(transport Transport, opts ...Option) (*TrainEngine, error) {
+	config := newEngineConfig(opts)
 	train := TrainEngine{
-		adapter:             adapter,
-		disconnected:        &disconnected,
-		device:              &device,
-		writeService:        &devicesServices[0],
-		writeCharacteristic: &characteristics[0],
+		transport: transport,
+		logger:    config.logger,
 		state: &TrainState{
 			Speed:        0,
 			Reverse:      false,
@@ -120,112 +111,121 @@ func NewEngine(trainAddress bluetooth.Address, adapter *bluetooth.Adapter) (*Tra
 			VolumeSpeech: 1,
 			//VolumeChuff:  1,
 		},
-		reconnect: true,
-	}
-
-	// fire off a new process to wait an listen for a disconnect
-	go func(engine *TrainEngine) {
-		for {
-			device := <-disconnected
-			log.Println("Device disconnected.")
-			if device.Address == engine.device.Address {
-				log.Println("Train disconnected.")
-				// the train is disconnected
-				if engine.reconnect {
-					log.Println("Attempting Reconnect")
-					new_device, err := adapter.Connect(engine.device.Address, connectionParams)
-					if err != nil {
-						log.Panic(err)
-						return
-					}
-					nds, err := new_device.DiscoverServices([]bluetooth.UUID{ReadWriteService})
-					if err != nil {
-						log.Panic(err)
-						return
-					}
-					if len(nds) < 1 {
-						log.Panic(errors.New("failed to find read/write service"))
-						return
-					}
-
-					ndc, err := nds[0].DiscoverCharacteristics([]bluetooth.UUID{WriteCharacteristic})
-					if err != nil {
-						log.Panic(err)
-						return
-					}
-
-					if len(ndc) < 1 {
-						log.Panic(errors.New("write characteristic not found"))
-					}
-
-					// once reconnected, resetup these links with the new stuff
-					engine.device = &device
-					engine.writeService = &devicesServices[0]
-					engine.writeCharacteristic = &characteristics[0]
-				} else {
-					break
-				}
-			}
-		}
+		notifications:   make(chan Notification, 32),
+		scheduler:       newCommandScheduler(commandQueueSize),
+		subscribers:     map[chan Event]struct{}{},
+		limiter:         newRateLimiter(config.rateLimit),
+		connState:       CONNECTIONSTATE_CONNECTING,
+		reconnectPolicy: config.reconnectPolicy,
+		restorePolicy:   config.restorePolicy,
+		linkClosed:      make(chan struct{}),
+		interlocks:      config.interlocks,
+		sounding:        map[SoundType]int{},
+		clock:           config.clock,
+	}
+	train.ctx, train.cancel = context.WithCancel(context.Background())
+	go train.runCommands()
+
+	// Listen to what the train tells us, not every transport (or train) supports this
+	err := transport.Subscribe(train.handleNotification)
+	if err != nil {
+		train.logger.Printf("Notifications unavailable: %v", err)
+	}
+
+	train.readInfo()
+	train.selectProfile()
 
-	}(&train)
 	// Make sure the train is in the default state (specifically Volumes) before we return it
-	err = train.ResetState()
-	if err != nil {
-		return nil, err
+	if config.resetOnConnect {
+		err = train.ResetState()
+		if err != nil {
+			train.cancel()
+			return nil, err
+		}
 	}
+
+	train.setConnectionState(CONNECTIONSTATE_CONNECTED, 0, nil)
+	if config.watchdog != nil {
+		err := train.EnableWatchdog(*config.watchdog)
+		if err != nil {
+			train.Disconnect()
+			return nil, err
+		}
+	}
+	// fire off a new process to follow the link state and reconnect when it drops
+	links := make(chan bool, 1)
+	go train.followLink(transport.ConnectionEvents(), links)
+	go train.watchConnection(links)
 	return &train, nil
 }
 
 func (a *TrainEngine) Disconnect() error {
-	a.reconnect = false
-	return a.device.Disconnect()
+	a.cancel()
+	a.setConnectionState(CONNECTIONSTATE_DISCONNECTED, 0, nil)
+	return a.transport.Close()
+}
+
+// selectProfile picks the profile matching what the train told us about itself
+func (a *TrainEngine) selectProfile() {
+	info := EngineInfo{}
+	if a.info != nil {
+		info = *a.info
+	}
+	profile := SelectProfile(info.ModelNumber, info.DeviceName)
+	a.profile.Store(profile)
+	a.logger.Printf("Using engine profile '%s'", profile.Name)
+}
+
+// Clock is what the engine times its watchdog by, see WithClock.
+func (a *TrainEngine) Clock() Clock {
+	return a.clock
+}
+
+func (a *TrainEngine) Profile() *EngineProfile {
+	return a.profile.Load()
+}
+
+// SetProfile overrides the automatically selected profile.
+func (a *TrainEngine) SetProfile(profile *EngineProfile) {
+	a.profile.Store(profile)
 }
 
 func (a *TrainEngine) ResetState() error {
-	(*a).state.Speed = 0
 	err := a.SetSpeed(0)
 	if err != nil {
 		return err
 	}
 
-	(*a).state.Reverse = false
 	err = a.SetReverse(false)
 	if err != nil {
 		return err
 	}
 
-	(*a).state.Light = true
 	err = a.SetLight(true)
 	if err != nil {
 		return err
 	}
 
-	(*a).state.Volume = 7
 	err = a.SetMainVolume(7)
 	if err != nil {
 		return err
 	}
 
-	(*a).state.VolumeHorn = 7
 	err = a.SetHornVolume(7)
 	if err != nil {
 		return err
 	}
 
-	(*a).state.VolumeEngine = 0
 	err = a.SetEngineVolume(0)
 	if err != nil {
 		return err
 	}
 
-	(*a).state.VolumeBell = 7
 	err = a.SetBellVolume(7)
 	if err != nil {
 		return err
 	}
 
-	(*a).state.VolumeSpeech = 7
 	err = a.SetSpeechVolume(7)
 	if err != nil {
 		return err
@@ -234,297 +234,250 @@ func (a *TrainEngine) ResetState() error {
 	return nil
 }
 
-func (a *TrainEngine) sendCommand(cmdByteArray []byte) error {
-	log.Println("sendCommand")
-	checksumedCmd := make([]byte, len(cmdByteArray)+2)
-	checksumedCmd[0] = 0
-	// Copy the values but offset them by 1
-	for i, v := range cmdByteArray {
-		checksumedCmd[i+1] = v
-	}
+func (a *TrainEngine) sendCommand(cmd protocol.Command) error {
+	return a.sendRequest(&commandRequest{cmd: cmd, priority: commandPriority(cmd)})
+}
 
-	checksumedCmd[len(cmdByteArray)+1] = calculateChecksum(cmdByteArray)
-	written, err := a.writeCharacteristic.WriteWithoutResponse(checksumedCmd)
+func (a *TrainEngine) sendRequest(request *commandRequest) error {
+	return a.sendRequestContext(context.Background(), request)
+}
 
+func (a *TrainEngine) sendRequestContext(ctx context.Context, request *commandRequest) error {
+	a.logger.Println("sendCommand")
+	frame, err := protocol.Marshal(request.cmd)
 	if err != nil {
 		return err
 	}
+	request.frame = frame
+	request.key = coalesceKey(request.cmd)
 
-	if written != len(checksumedCmd) {
-		return fmt.Errorf("writing command only wrote '%v' bytes of '%v'", written, len(checksumedCmd))
+	err = a.submitContext(ctx, request)
+	if err != nil {
+		return err
 	}
-	log.Println("sendCommand-Done")
+	a.logger.Println("sendCommand-Done")
 	return nil
 }
 
 func (a *TrainEngine) SetMainVolume(volume int) error {
-	log.Println("SetMainVolume")
-	defer log.Println("SetMainVolume-Done")
-	min := int(0)
-	max := int(7)
+	a.logger.Println("SetMainVolume")
+	defer a.logger.Println("SetMainVolume-Done")
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_MASTER_VOLUME)
+	if err != nil {
+		return err
+	}
+	min := profile.MasterVolume.Min
+	max := profile.MasterVolume.Max
 	if volume > max || volume < min {
 		return fmt.Errorf("invalid volume, must be between '%d' and '%d' (inclusive)", min, max)
 	}
 
-	cmdArray := make([]byte, 2)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_MAIN)
-	cmdArray[1] = byte(volume)
-	err := a.sendCommand(cmdArray)
-	if err == nil {
-		(*a).state.Volume = volume
-	}
-	return err
+	return a.sendCommand(protocol.MasterVolume{Level: uint8(volume)})
 }
 
 func (a *TrainEngine) SetBellVolume(volume int) error {
-	log.Println("SetBellVolume")
-	defer log.Println("SetBellVolume-Done")
-	min := int(0)
-	max := int(13)
-	if volume > max || volume < min {
-		return fmt.Errorf("invalid volume, must be between '%d' and '%d' (inclusive)", min, max)
-	}
-
-	cmdArray := make([]byte, 3)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_RUNNING)
-	cmdArray[1] = byte(SOUNDTYPE_BELL)
-	cmdArray[2] = byte(volume)
-	err := a.sendCommand(cmdArray)
-	if err == nil {
-		(*a).state.VolumeBell = volume
-	}
-	return err
+	a.logger.Println("SetBellVolume")
+	defer a.logger.Println("SetBellVolume-Done")
+	return a.setSoundVolume(SOUNDTYPE_BELL, volume)
 }
 
 func (a *TrainEngine) SetEngineVolume(volume int) error {
-	log.Println("SetEngineVolume")
-	defer log.Println("SetEngineVolume-Done")
-	min := int(0)
-	max := int(13)
-	if volume > max || volume < min {
-		return fmt.Errorf("invalid volume, must be between '%d' and '%d' (inclusive)", min, max)
-	}
-
-	cmdArray := make([]byte, 3)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_RUNNING)
-	cmdArray[1] = byte(SOUNDTYPE_ENGINE)
-	cmdArray[2] = byte(volume)
-	err := a.sendCommand(cmdArray)
-	if err == nil {
-		(*a).state.VolumeEngine = volume
-	}
-	return err
+	a.logger.Println("SetEngineVolume")
+	defer a.logger.Println("SetEngineVolume-Done")
+	return a.setSoundVolume(SOUNDTYPE_ENGINE, volume)
 }
 
 func (a *TrainEngine) SetHornVolume(volume int) error {
-	log.Println("SetHornVolume")
-	defer log.Println("SetHornVolume-Done")
-	min := int(0)
-	max := int(13)
-	if volume > max || volume < min {
-		return fmt.Errorf("invalid volume, must be between '%d' and '%d' (inclusive)", min, max)
-	}
-
-	cmdArray := make([]byte, 3)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_RUNNING)
-	cmdArray[1] = byte(SOUNDTYPE_HORN)
-	cmdArray[2] = byte(volume)
-	err := a.sendCommand(cmdArray)
-	if err == nil {
-		(*a).state.VolumeHorn = volume
-	}
-	return err
+	a.logger.Println("SetHornVolume")
+	defer a.logger.Println("SetHornVolume-Done")
+	return a.setSoundVolume(SOUNDTYPE_HORN, volume)
 }
 
 func (a *TrainEngine) SetSpeechVolume(volume int) error {
-	log.Println("SetSpeechVolume")
-	defer log.Println("SetSpeechVolume-Done")
-	min := int(0)
-	max := int(13)
+	a.logger.Println("SetSpeechVolume")
+	defer a.logger.Println("SetSpeechVolume-Done")
+	return a.setSoundVolume(SOUNDTYPE_SPEECH, volume)
+}
+
+func (a *TrainEngine) setSoundVolume(soundType SoundType, volume int) error {
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_SOUND_VOLUME)
+	if err != nil {
+		return err
+	}
+	volumeRange := profile.VolumeRange(soundType)
+	min := volumeRange.Min
+	max := volumeRange.Max
 	if volume > max || volume < min {
 		return fmt.Errorf("invalid volume, must be between '%d' and '%d' (inclusive)", min, max)
 	}
 
-	cmdArray := make([]byte, 3)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_RUNNING)
-	cmdArray[1] = byte(SOUNDTYPE_SPEECH)
-	cmdArray[2] = byte(volume)
-	err := a.sendCommand(cmdArray)
-	if err == nil {
-		(*a).state.VolumeSpeech = volume
-	}
-	return err
+	return a.sendCommand(protocol.SetSoundVolume{Type: protocol.SoundType(soundType), Level: uint8(volume)})
 }
 
 func (a *TrainEngine) SetBellPitch(pitch SoundPitch) error {
-	log.Println("SetBellPitch")
-	defer log.Println("SetBellPitch-Done")
-	validPitches := []int{SOUNDPITCH_HIGHEST, SOUNDPITCH_HIGH, SOUNDPITCH_NORMAL, SOUNDPITCH_LOW, SOUNDPITCH_LOWEST}
-	if !slices.Contains(validPitches, int(pitch)) {
-		return fmt.Errorf("invalid pitch, must be one of 'SOUNDPITCH_HIGHEST, SOUNDPITCH_HIGH, SOUNDPITCH_NORMAL, SOUNDPITCH_LOW, SOUNDPITCH_LOWEST' or int of '%v'", validPitches)
-	}
-
-	cmdArray := make([]byte, 4)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_RUNNING)
-	cmdArray[1] = byte(SOUNDTYPE_BELL)
-	cmdArray[2] = byte(14)
-	cmdArray[3] = byte(pitch)
-	err := a.sendCommand(cmdArray)
-	return err
+	a.logger.Println("SetBellPitch")
+	defer a.logger.Println("SetBellPitch-Done")
+	return a.setSoundPitch(SOUNDTYPE_BELL, pitch)
 }
-func (a *TrainEngine) SetEnginePitch(pitch SoundPitch) error {
-	log.Println("SetEnginePitch")
-	defer log.Println("SetEnginePitch-Done")
-	validPitches := []int{SOUNDPITCH_HIGHEST, SOUNDPITCH_HIGH, SOUNDPITCH_NORMAL, SOUNDPITCH_LOW, SOUNDPITCH_LOWEST}
-	if !slices.Contains(validPitches, int(pitch)) {
-		return fmt.Errorf("invalid pitch, must be one of 'SOUNDPITCH_HIGHEST, SOUNDPITCH_HIGH, SOUNDPITCH_NORMAL, SOUNDPITCH_LOW, SOUNDPITCH_LOWEST' or int of '%v'", validPitches)
-	}
 
-	cmdArray := make([]byte, 4)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_RUNNING)
-	cmdArray[1] = byte(SOUNDTYPE_ENGINE)
-	cmdArray[2] = byte(14)
-	cmdArray[3] = byte(pitch)
-	err := a.sendCommand(cmdArray)
-	return err
+func (a *TrainEngine) SetEnginePitch(pitch SoundPitch) error {
+	a.logger.Println("SetEnginePitch")
+	defer a.logger.Println("SetEnginePitch-Done")
+	return a.setSoundPitch(SOUNDTYPE_ENGINE, pitch)
 }
-func (a *TrainEngine) SetHornPitch(pitch SoundPitch) error {
-	log.Println("SetHornPitch")
-	defer log.Println("SetHornPitch-Done")
-	validPitches := []int{SOUNDPITCH_HIGHEST, SOUNDPITCH_HIGH, SOUNDPITCH_NORMAL, SOUNDPITCH_LOW, SOUNDPITCH_LOWEST}
-	if !slices.Contains(validPitches, int(pitch)) {
-		return fmt.Errorf("invalid pitch, must be one of 'SOUNDPITCH_HIGHEST, SOUNDPITCH_HIGH, SOUNDPITCH_NORMAL, SOUNDPITCH_LOW, SOUNDPITCH_LOWEST' or int of '%v'", validPitches)
-	}
 
-	cmdArray := make([]byte, 4)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_RUNNING)
-	cmdArray[1] = byte(SOUNDTYPE_HORN)
-	cmdArray[2] = byte(14)
-	cmdArray[3] = byte(pitch)
-	err := a.sendCommand(cmdArray)
-	return err
+func (a *TrainEngine) SetHornPitch(pitch SoundPitch) error {
+	a.logger.Println("SetHornPitch")
+	defer a.logger.Println("SetHornPitch-Done")
+	return a.setSoundPitch(SOUNDTYPE_HORN, pitch)
 }
+
 func (a *TrainEngine) SetSpeechPitch(pitch SoundPitch) error {
-	log.Println("SetSpeechPitch")
-	defer log.Println("SetSpeechPitch-Done")
+	a.logger.Println("SetSpeechPitch")
+	defer a.logger.Println("SetSpeechPitch-Done")
+	return a.setSoundPitch(SOUNDTYPE_SPEECH, pitch)
+}
+
+func (a *TrainEngine) setSoundPitch(soundType SoundType, pitch SoundPitch) error {
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_SOUND_PITCH)
+	if err != nil {
+		return err
+	}
 	validPitches := []int{SOUNDPITCH_HIGHEST, SOUNDPITCH_HIGH, SOUNDPITCH_NORMAL, SOUNDPITCH_LOW, SOUNDPITCH_LOWEST}
 	if !slices.Contains(validPitches, int(pitch)) {
 		return fmt.Errorf("invalid pitch, must be one of 'SOUNDPITCH_HIGHEST, SOUNDPITCH_HIGH, SOUNDPITCH_NORMAL, SOUNDPITCH_LOW, SOUNDPITCH_LOWEST' or int of '%v'", validPitches)
 	}
 
-	cmdArray := make([]byte, 4)
-	cmdArray[0] = byte(COMMANDTYPE_SOUND_RUNNING)
-	cmdArray[1] = byte(SOUNDTYPE_SPEECH)
-	cmdArray[2] = byte(14)
-	cmdArray[3] = byte(pitch)
-	err := a.sendCommand(cmdArray)
-	return err
+	return a.sendCommand(protocol.SetSoundPitch{Type: protocol.SoundType(soundType), Pitch: wirePitch(pitch)})
+}
+
+// wirePitch converts the byte valued SOUNDPITCH_* constants into the signed protocol pitch
+func wirePitch(pitch SoundPitch) protocol.Pitch {
+	return protocol.Pitch(int8(byte(pitch)))
 }
 
 func (a *TrainEngine) SetSpeed(speed int) error {
-	log.Println("SetSpeed")
-	defer log.Println("SetSpeed-Done")
-	cmdArray := make([]byte, 2)
-	cmdArray[0] = byte(COMMANDTYPE_SPEED)
-	cmdArray[1] = byte(speed)
-	err := a.sendCommand(cmdArray)
-	(*a).state.Speed = speed
-	return err
+	return a.setSpeed(speed, false, 0)
+}
+
+func (a *TrainEngine) setSpeed(speed int, guarded bool, emergencyStops uint64) error {
+	a.logger.Println("SetSpeed")
+	defer a.logger.Println("SetSpeed-Done")
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_SPEED)
+	if err != nil {
+		return err
+	}
+	if !profile.Speed.Contains(speed) {
+		return fmt.Errorf("invalid speed, must be between '%d' and '%d' (inclusive)", profile.Speed.Min, profile.Speed.Max)
+	}
+
+	return a.sendRequest(&commandRequest{
+		cmd:      protocol.SetSpeed{Speed: uint8(speed)},
+		priority: PRIORITY_MOTION,
+		guarded:  guarded,
+		stops:    emergencyStops,
+	})
 }
 
 func (a *TrainEngine) GetSpeed() int {
-	return (*a).state.Speed
+	a.stateLock.RLock()
+	defer a.stateLock.RUnlock()
+	return a.state.Speed
 }
 
 func (a *TrainEngine) SetHorn(enabled bool) error {
-	log.Println("SetHorn")
-	defer log.Println("SetHorn-Done")
-	cmdArray := make([]byte, 2)
-	cmdArray[0] = byte(COMMANDTYPE_HORN)
-	var soundHorn int
-	if enabled {
-		soundHorn = 1
-	} else {
-		soundHorn = 0
+	a.logger.Println("SetHorn")
+	defer a.logger.Println("SetHorn-Done")
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_HORN)
+	if err != nil {
+		return err
 	}
-
-	cmdArray[1] = byte(soundHorn)
-	err := a.sendCommand(cmdArray)
-	return err
+	return a.sendCommand(protocol.Horn{On: enabled})
 }
 
 func (a *TrainEngine) SetReverse(enabled bool) error {
-	log.Println("SetReverse")
-	defer log.Println("SetReverse-Done")
-	cmdArray := make([]byte, 2)
-	cmdArray[0] = byte(COMMANDTYPE_REVERSE)
-	var soundHorn int
+	a.logger.Println("SetReverse")
+	defer a.logger.Println("SetReverse-Done")
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_DIRECTION)
+	if err != nil {
+		return err
+	}
+	direction := protocol.DirectionForward
 	if enabled {
-		soundHorn = 1
-	} else {
-		soundHorn = 0
+		direction = protocol.DirectionReverse
 	}
 
-	cmdArray[1] = byte(soundHorn)
-	err := a.sendCommand(cmdArray)
-	(*a).state.Reverse = enabled
-	return err
+	return a.sendCommand(protocol.SetDirection{Direction: direction})
 }
 
 func (a *TrainEngine) GetReverse() bool {
-	return (*a).state.Reverse
+	a.stateLock.RLock()
+	defer a.stateLock.RUnlock()
+	return a.state.Reverse
 }
 
 func (a *TrainEngine) SetBell(enabled bool) error {
-	log.Println("SetBell")
-	defer log.Println("SetBell-Done")
-	cmdArray := make([]byte, 2)
-	cmdArray[0] = byte(COMMANDTYPE_BELL)
-	var soundHorn int
-	if enabled {
-		soundHorn = 1
-	} else {
-		soundHorn = 0
+	a.logger.Println("SetBell")
+	defer a.logger.Println("SetBell-Done")
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_BELL)
+	if err != nil {
+		return err
 	}
-
-	cmdArray[1] = byte(soundHorn)
-	err := a.sendCommand(cmdArray)
-	return err
+	return a.sendCommand(protocol.Bell{On: enabled})
 }
 
 func (a *TrainEngine) SetLight(enabled bool) error {
-	log.Println("SetLight")
-	defer log.Println("SetLight-Done")
-	cmdArray := make([]byte, 2)
-	cmdArray[0] = byte(COMMANDTYPE_LIGHTS)
-	var soundHorn int
-	if enabled {
-		soundHorn = 1
-	} else {
-		soundHorn = 0
+	a.logger.Println("SetLight")
+	defer a.logger.Println("SetLight-Done")
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_LIGHTS)
+	if err != nil {
+		return err
 	}
-
-	cmdArray[1] = byte(soundHorn)
-	err := a.sendCommand(cmdArray)
-	(*a).state.Light = enabled
-	return err
+	return a.sendCommand(protocol.Lights{On: enabled})
 }
 
 func (a *TrainEngine) GetLight() bool {
-	return (*a).state.Light
+	a.stateLock.RLock()
+	defer a.stateLock.RUnlock()
+	return a.state.Light
 }
 
 func (a *TrainEngine) SpeakPhrase(phrase SpeechPhrase) error {
-	log.Printf("SpeakPhrase called with '%v' as argument", phrase)
-
-	cmdArray := make([]byte, 2)
-	cmdArray[0] = byte(COMMANDTYPE_SPEAK)
-	cmdArray[1] = byte(phrase)
-
-	err := a.sendCommand(cmdArray)
-	return err
+	a.logger.Printf("SpeakPhrase called with '%v' as argument", phrase)
+	profile := a.Profile()
+	err := profile.check(CAPABILITY_SPEAK)
+	if err != nil {
+		return err
+	}
+	if len(profile.Phrases) > 0 {
+		if _, ok := profile.Phrase(int(phrase)); !ok {
+			return fmt.Errorf("invalid phrase '%d', not known to '%s'", phrase, profile.Name)
+		}
+	} else if phrase < 0 || phrase > math.MaxUint8 {
+		// the phrase is a single byte on the wire, larger ones would wrap around
+		return fmt.Errorf("invalid phrase, must be between '0' and '%d' (inclusive)", math.MaxUint8)
+	}
+	return a.sendCommand(protocol.Speak{Phrase: uint8(phrase)})
 }
 
+// SendCustomCommand frames and sends a raw payload (opcode first). Payloads the protocol
+// package understands are validated first; unknown opcodes are passed through untouched
+// so new commands can be experimented with.
 func (a *TrainEngine) SendCustomCommand(cmd []byte) error {
-	return a.sendCommand(cmd)
+	parsed, err := protocol.ParsePayload(cmd)
+	if err == nil {
+		return a.sendCommand(parsed)
+	}
+	if !errors.Is(err, protocol.ErrUnknownOpcode) {
+		return err
+	}
+	return a.submit(&commandRequest{frame: protocol.Frame(cmd), priority: PRIORITY_SOUND})
 }
@@ -0,0 +1,135 @@
+package lionchief
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func TestNewEngineResetsTrain(t *testing.T) {
+	transport := NewMemoryTransport()
+	newTestEngineWith(t, transport)
+	assertFrames(t, transport,
+		protocol.SetSpeed{Speed: 0},
+		protocol.SetDirection{Direction: protocol.DirectionForward},
+		protocol.Lights{On: true},
+		protocol.MasterVolume{Level: 7},
+		protocol.SetSoundVolume{Type: protocol.SoundHorn, Level: 7},
+		protocol.SetSoundVolume{Type: protocol.SoundEngine, Level: 0},
+		protocol.SetSoundVolume{Type: protocol.SoundBell, Level: 7},
+		protocol.SetSoundVolume{Type: protocol.SoundSpeech, Level: 7},
+	)
+}
+
+func TestSetterFrames(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		call     func(engine *TrainEngine) error
+		expected protocol.Command
+	}{
+		{"SetSpeed", func(a *TrainEngine) error { return a.SetSpeed(12) }, protocol.SetSpeed{Speed: 12}},
+		{"SetReverse", func(a *TrainEngine) error { return a.SetReverse(true) }, protocol.SetDirection{Direction: protocol.DirectionReverse}},
+		{"SetLight", func(a *TrainEngine) error { return a.SetLight(false) }, protocol.Lights{On: false}},
+		{"SetHorn", func(a *TrainEngine) error { return a.SetHorn(true) }, protocol.Horn{On: true}},
+		{"SetBell", func(a *TrainEngine) error { return a.SetBell(true) }, protocol.Bell{On: true}},
+		{"SetMainVolume", func(a *TrainEngine) error { return a.SetMainVolume(3) }, protocol.MasterVolume{Level: 3}},
+		{"SetHornVolume", func(a *TrainEngine) error { return a.SetHornVolume(9) }, protocol.SetSoundVolume{Type: protocol.SoundHorn, Level: 9}},
+		{"SetBellVolume", func(a *TrainEngine) error { return a.SetBellVolume(2) }, protocol.SetSoundVolume{Type: protocol.SoundBell, Level: 2}},
+		{"SetSpeechVolume", func(a *TrainEngine) error { return a.SetSpeechVolume(4) }, protocol.SetSoundVolume{Type: protocol.SoundSpeech, Level: 4}},
+		{"SetEngineVolume", func(a *TrainEngine) error { return a.SetEngineVolume(13) }, protocol.SetSoundVolume{Type: protocol.SoundEngine, Level: 13}},
+		{"SetHornPitch", func(a *TrainEngine) error { return a.SetHornPitch(SoundPitch(SOUNDPITCH_LOW)) }, protocol.SetSoundPitch{Type: protocol.SoundHorn, Pitch: protocol.PitchLow}},
+		{"SetEnginePitch", func(a *TrainEngine) error { return a.SetEnginePitch(SoundPitch(SOUNDPITCH_HIGHEST)) }, protocol.SetSoundPitch{Type: protocol.SoundEngine, Pitch: protocol.PitchHighest}},
+		{"SpeakPhrase", func(a *TrainEngine) error { return a.SpeakPhrase(SpeechPhrase(2)) }, protocol.Speak{Phrase: 2}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine, transport := newTestEngine(t)
+			err := test.call(engine)
+			if err != nil {
+				t.Fatalf("%s failed: %v", test.name, err)
+			}
+			assertFrames(t, transport, test.expected)
+		})
+	}
+}
+
+func TestSetterValidation(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	for name, call := range map[string]func() error{
+		"speed":           func() error { return engine.SetSpeed(32) },
+		"master":          func() error { return engine.SetMainVolume(8) },
+		"horn volume":     func() error { return engine.SetHornVolume(14) },
+		"engine pitch":    func() error { return engine.SetEnginePitch(SoundPitch(3)) },
+		"phrase":          func() error { return engine.SpeakPhrase(SpeechPhrase(256)) },
+		"negative phrase": func() error { return engine.SpeakPhrase(SpeechPhrase(-1)) },
+	} {
+		if err := call(); err == nil {
+			t.Errorf("out of range %s was accepted", name)
+		}
+	}
+	assertFrames(t, transport)
+}
+
+func TestSendCustomCommand(t *testing.T) {
+	engine, transport := newTestEngine(t)
+
+	// known commands go through the codec, and its validation
+	err := engine.SendCustomCommand([]byte{0x45, 0x04})
+	if err != nil {
+		t.Fatalf("SendCustomCommand of a speed failed: %v", err)
+	}
+	err = engine.SendCustomCommand([]byte{0x45, 0x40})
+	if !errors.Is(err, protocol.ErrOutOfRange) {
+		t.Fatalf("SendCustomCommand of speed 0x40 returned %v, expected %v", err, protocol.ErrOutOfRange)
+	}
+	// unknown ones are passed through untouched
+	err = engine.SendCustomCommand([]byte{0x60, 0x01})
+	if err != nil {
+		t.Fatalf("SendCustomCommand of an unknown opcode failed: %v", err)
+	}
+
+	frames := transport.Frames()
+	if len(frames) != 2 || string(frames[1]) != string([]byte{0x00, 0x60, 0x01, 0x61}) {
+		t.Fatalf("frames written:\n%s", describeFrames(frames))
+	}
+	if engine.GetSpeed() != 4 {
+		t.Errorf("speed is '%d' after a custom speed command, expected '4'", engine.GetSpeed())
+	}
+}
+
+// newTestSimulator wraps a test engine with instant momentum and quick horn signals
+func newTestSimulator(t *testing.T, opts ...Option) (*TrainSimulator, *MemoryTransport) {
+	t.Helper()
+	engine, transport := newTestEngine(t, opts...)
+	simulator := NewSimulatorWithEngine(engine)
+	err := simulator.SetMomentum(Momentum{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	simulator.SetHornTiming(testHornTiming)
+	return simulator, transport
+}
+
+func TestReverseTrainService(t *testing.T) {
+	simulator, transport := newTestSimulator(t)
+	err := simulator.engine.SetSpeed(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.Reset()
+
+	err = simulator.ReverseTrainService()
+	if err != nil {
+		t.Fatalf("ReverseTrainService failed: %v", err)
+	}
+	hornOn, hornOff := protocol.Horn{On: true}, protocol.Horn{On: false}
+	assertFrames(t, transport,
+		protocol.SetSpeed{Speed: 1},
+		protocol.SetSpeed{Speed: 0},
+		// three shorts, back up
+		hornOn, hornOff, hornOn, hornOff, hornOn, hornOff,
+		protocol.SetDirection{Direction: protocol.DirectionReverse},
+		protocol.SetSpeed{Speed: 1},
+		protocol.SetSpeed{Speed: 2},
+	)
+}
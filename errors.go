@@ -0,0 +1,10 @@
+package lionchief
+
+import "errors"
+
+var (
+	ErrAdapterUnavailable     = errors.New("bluetooth adapter unavailable")
+	ErrServiceNotFound        = errors.New("read/write service not found")
+	ErrCharacteristicNotFound = errors.New("characteristic not found")
+	ErrConnectTimeout         = errors.New("timed out connecting to train")
+)
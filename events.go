@@ -0,0 +1,104 @@
+package lionchief
+
+import (
+	"time"
+)
+
+type EventType int
+
+const (
+	EVENT_SPEED EventType = iota
+	EVENT_DIRECTION
+	EVENT_LIGHTS
+	EVENT_VOLUME
+	EVENT_PITCH
+	EVENT_HORN
+	EVENT_BELL
+	EVENT_PHRASE
+	EVENT_CONNECTION
+	EVENT_WATCHDOG
+)
+
+func (a EventType) String() string {
+	switch a {
+	case EVENT_SPEED:
+		return "Speed"
+	case EVENT_DIRECTION:
+		return "Direction"
+	case EVENT_LIGHTS:
+		return "Lights"
+	case EVENT_VOLUME:
+		return "Volume"
+	case EVENT_PITCH:
+		return "Pitch"
+	case EVENT_HORN:
+		return "Horn"
+	case EVENT_BELL:
+		return "Bell"
+	case EVENT_PHRASE:
+		return "Phrase"
+	case EVENT_CONNECTION:
+		return "Connection"
+	case EVENT_WATCHDOG:
+		return "Watchdog"
+	}
+	return "Unknown"
+}
+
+// Event is a single change to the train. Before and After hold the old and new value:
+// an int for speed and volumes, a bool for direction (true is reverse), lights, horn and bell,
+// a SoundPitch for pitch, a SpeechPhrase for phrases and a ConnectionState for the connection. A watchdog event
+// holds the speed when it tripped and the speed it left the train at.
+// Sound says which sound a volume, pitch or phrase event is about, 0 for the master volume.
+type Event struct {
+	Type   EventType
+	Sound  SoundType
+	Before any
+	After  any
+	Time   time.Time
+}
+
+// eventBufferSize is how many events a subscriber may fall behind before events are dropped
+const eventBufferSize = 64
+
+// Subscribe returns a channel of every change to the train, and a function to stop listening.
+// Subscribers that fall too far behind miss events rather than stall the engine.
+func (a *TrainEngine) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, eventBufferSize)
+
+	a.eventLock.Lock()
+	a.subscribers[events] = struct{}{}
+	a.eventLock.Unlock()
+
+	unsubscribe := func() {
+		a.eventLock.Lock()
+		defer a.eventLock.Unlock()
+		if _, ok := a.subscribers[events]; ok {
+			delete(a.subscribers, events)
+			close(events)
+		}
+	}
+	return events, unsubscribe
+}
+
+func (a *TrainEngine) publish(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	now := time.Now()
+	a.eventLock.Lock()
+	defer a.eventLock.Unlock()
+	for _, event := range events {
+		if event.Time.IsZero() {
+			event.Time = now
+		}
+		for subscriber := range a.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+				a.logger.Printf("Dropping '%v' event, subscriber is not keeping up", event.Type)
+			}
+		}
+	}
+}
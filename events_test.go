@@ -0,0 +1,111 @@
+package lionchief
+
+import (
+	"testing"
+)
+
+// drainEvents takes every event already published, without waiting for more
+func drainEvents(events <-chan Event) []Event {
+	var drained []Event
+	for {
+		select {
+		case event := <-events:
+			drained = append(drained, event)
+		default:
+			return drained
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		call     func(engine *TrainEngine) error
+		expected []Event
+	}{
+		{"speed", func(a *TrainEngine) error { return a.SetSpeed(5) }, []Event{{Type: EVENT_SPEED, Before: 0, After: 5}}},
+		{"unchanged speed", func(a *TrainEngine) error { return a.SetSpeed(0) }, nil},
+		{"direction", func(a *TrainEngine) error { return a.SetReverse(true) }, []Event{{Type: EVENT_DIRECTION, Before: false, After: true}}},
+		{"lights", func(a *TrainEngine) error { return a.SetLight(false) }, []Event{{Type: EVENT_LIGHTS, Before: true, After: false}}},
+		{"horn", func(a *TrainEngine) error { return a.SetHorn(true) }, []Event{{Type: EVENT_HORN, Sound: SOUNDTYPE_HORN, Before: false, After: true}}},
+		{"bell", func(a *TrainEngine) error { return a.SetBell(true) }, []Event{{Type: EVENT_BELL, Sound: SOUNDTYPE_BELL, Before: false, After: true}}},
+		{"master volume", func(a *TrainEngine) error { return a.SetMainVolume(3) }, []Event{{Type: EVENT_VOLUME, Before: 7, After: 3}}},
+		{"horn volume", func(a *TrainEngine) error { return a.SetHornVolume(2) }, []Event{{Type: EVENT_VOLUME, Sound: SOUNDTYPE_HORN, Before: 7, After: 2}}},
+		{"engine pitch", func(a *TrainEngine) error { return a.SetEnginePitch(SoundPitch(SOUNDPITCH_LOW)) }, []Event{{Type: EVENT_PITCH, Sound: SOUNDTYPE_ENGINE, Before: SoundPitch(SOUNDPITCH_NORMAL), After: SoundPitch(SOUNDPITCH_LOW)}}},
+		{"phrase", func(a *TrainEngine) error { return a.SpeakPhrase(SpeechPhrase(2)) }, []Event{{Type: EVENT_PHRASE, Sound: SOUNDTYPE_SPEECH, Before: nil, After: SpeechPhrase(2)}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine, _ := newTestEngine(t)
+			events, unsubscribe := engine.Subscribe()
+			defer unsubscribe()
+
+			err := test.call(engine)
+			if err != nil {
+				t.Fatalf("%s failed: %v", test.name, err)
+			}
+			published := drainEvents(events)
+			if len(published) != len(test.expected) {
+				t.Fatalf("'%d' events published, expected '%d': %+v", len(published), len(test.expected), published)
+			}
+			for i, event := range published {
+				if event.Time.IsZero() {
+					t.Errorf("event '%d' has no time", i)
+				}
+				event.Time = test.expected[i].Time
+				if event != test.expected[i] {
+					t.Errorf("event '%d' is '%+v', expected '%+v'", i, event, test.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	events, unsubscribe := engine.Subscribe()
+	unsubscribe()
+	// a second call must not close the channel again
+	unsubscribe()
+
+	err := engine.SetSpeed(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("event received after unsubscribing")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	err := engine.SetSpeed(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.SpeakPhrase(SpeechPhrase(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := engine.Snapshot()
+	if snapshot.Version != TrainStateVersion {
+		t.Errorf("snapshot has version '%d', expected '%d'", snapshot.Version, TrainStateVersion)
+	}
+	if snapshot.Connection != CONNECTIONSTATE_CONNECTED {
+		t.Errorf("snapshot has connection '%v', expected '%v'", snapshot.Connection, CONNECTIONSTATE_CONNECTED)
+	}
+	if snapshot.Speed != 4 || snapshot.VolumeHorn != 7 || !snapshot.Light {
+		t.Errorf("snapshot '%+v' does not match the commands sent", snapshot)
+	}
+	if snapshot.LastPhrase == nil || *snapshot.LastPhrase != 1 {
+		t.Fatalf("snapshot has last phrase '%v', expected '1'", snapshot.LastPhrase)
+	}
+
+	// the snapshot is a copy, changing it leaves the engine alone
+	*snapshot.LastPhrase = 9
+	snapshot.Speed = 20
+	again := engine.Snapshot()
+	if *again.LastPhrase != 1 || again.Speed != 4 {
+		t.Errorf("changing a snapshot changed the engine state to '%+v'", again)
+	}
+}
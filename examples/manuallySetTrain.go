@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"time"
+
 	"github.com/jasper-186/lionchief"
 	"tinygo.org/x/bluetooth"
 )
@@ -22,8 +25,12 @@ func manuallySetTrain() {
 		MACAddress: bluetooth.MACAddress{MAC: address},
 	}
 
+	// Give up if the train cannot be reached within 30 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
 	// Get a simulator
-	simulator, err := lionchief.NewSimulator(trainAddress)
+	simulator, err := lionchief.NewSimulatorContext(ctx, trainAddress, lionchief.WithAdapter(adapter))
 	if err != nil {
 		panic("failed to " + "begin simulation" + ": " + err.Error())
 	} else {
@@ -33,7 +40,19 @@ func manuallySetTrain() {
 	if simulator == nil {
 		panic("Simulator is nil")
 	}
+	// Stop the train rather than leave it running if we are killed
+	shutdown, stopListening := simulator.ShutdownOnSignal()
+	defer stopListening()
 
 	simulator.SoundBell(2)
 
+	// killed while the bell rang, the train has already been let go of
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			println("failed to " + "shut down" + ": " + err.Error())
+		}
+	default:
+	}
+
 }
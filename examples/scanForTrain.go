@@ -1,45 +1,31 @@
 package main
 
 import (
+	"context"
+	"time"
+
 	"github.com/jasper-186/lionchief"
-	"tinygo.org/x/bluetooth"
 )
 
 func scanForTrain() {
 
-	adapter := bluetooth.DefaultAdapter
-
 	// After you determine the name of your train set it here
 	trainName := "LC-0-1-0429-754D"
 
-	// Enable BLE interface.
-	err := adapter.Enable()
-	if err != nil {
-		panic("failed to " + "enable BLE stack" + ": " + err.Error())
-	} else {
-		println("enable BLE stack")
-	}
+	// Give up if the train does not show up within a minute
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
 
-	// Start scanning.
-	var trainAddress bluetooth.Address
 	println("scanning...")
-	err = adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
-		println("found device:", device.Address.String(), device.RSSI, device.LocalName())
-
-		if device.LocalName() == trainName {
-			trainAddress = device.Address
-			adapter.StopScan()
-		}
-	})
-
+	train, err := lionchief.FindTrain(ctx, trainName)
 	if err != nil {
-		panic("failed to " + "start scan" + ": " + err.Error())
+		panic("failed to " + "find train" + ": " + err.Error())
 	} else {
-		println("start scan")
+		println("found train:", train.Address.String(), train.RSSI, train.LocalName)
 	}
 
 	// Get a simulator
-	simulator, err := lionchief.NewSimulator(trainAddress)
+	simulator, err := lionchief.NewSimulator(train.Address)
 
 	if err != nil {
 		panic("failed to " + "begin simulation" + ": " + err.Error())
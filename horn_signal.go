@@ -0,0 +1,261 @@
+package lionchief
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HornSignal is a horn or whistle signal written in a mini-notation: '-' is a long sound,
+// '.' a short one and a space a longer pause between groups, e.g. "-- . --".
+type HornSignal struct {
+	Name    string
+	Pattern string
+	Meaning string
+}
+
+// HornTiming is how long each part of a signal lasts.
+type HornTiming struct {
+	Long  time.Duration
+	Short time.Duration
+	// Silence between sounds
+	Gap time.Duration
+	// Silence a space stands for
+	Pause time.Duration
+}
+
+var DefaultHornTiming = HornTiming{
+	Long:  1500 * time.Millisecond,
+	Short: 400 * time.Millisecond,
+	Gap:   400 * time.Millisecond,
+	Pause: 1200 * time.Millisecond,
+}
+
+// Names of the standard signals, as in the operating rules most North American railroads follow
+const (
+	HORNSIGNAL_STOP           = "stop"
+	HORNSIGNAL_PROCEED        = "proceed"
+	HORNSIGNAL_ACKNOWLEDGE    = "acknowledge"
+	HORNSIGNAL_BACK_UP        = "back_up"
+	HORNSIGNAL_REQUEST_SIGNAL = "request_signal"
+	HORNSIGNAL_GRADE_CROSSING = "grade_crossing"
+	HORNSIGNAL_ALARM          = "alarm"
+)
+
+// hornSignals is set up as a variable rather than in init so that scripts parsed into
+// package variables can already look the standard signals up
+var (
+	hornSignalsLock sync.RWMutex
+	hornSignals     = standardHornSignals()
+)
+
+func standardHornSignals() map[string]HornSignal {
+	signals := map[string]HornSignal{}
+	for _, signal := range []HornSignal{
+		{Name: HORNSIGNAL_STOP, Pattern: ".", Meaning: "Stopped, air brakes applied"},
+		{Name: HORNSIGNAL_PROCEED, Pattern: "--", Meaning: "Release air brakes, proceed"},
+		{Name: HORNSIGNAL_ACKNOWLEDGE, Pattern: "..", Meaning: "Acknowledge any signal not otherwise provided for"},
+		{Name: HORNSIGNAL_BACK_UP, Pattern: "...", Meaning: "When stopped, back up"},
+		{Name: HORNSIGNAL_REQUEST_SIGNAL, Pattern: "....", Meaning: "Request a signal be given or repeated"},
+		{Name: HORNSIGNAL_GRADE_CROSSING, Pattern: "--.-", Meaning: "Approaching a public grade crossing"},
+		{Name: HORNSIGNAL_ALARM, Pattern: "........", Meaning: "Alarm for persons or livestock on the track"},
+	} {
+		must("register horn signal '"+signal.Name+"'", ValidateHornPattern(signal.Pattern))
+		signals[signal.Name] = signal
+	}
+	return signals
+}
+
+// RegisterHornSignal adds a named signal, replacing any of the same name.
+func RegisterHornSignal(signal HornSignal) error {
+	if signal.Name == "" {
+		return fmt.Errorf("horn signal needs a name")
+	}
+	err := ValidateHornPattern(signal.Pattern)
+	if err != nil {
+		return err
+	}
+	hornSignalsLock.Lock()
+	defer hornSignalsLock.Unlock()
+	hornSignals[signal.Name] = signal
+	return nil
+}
+
+func LookupHornSignal(name string) (HornSignal, bool) {
+	hornSignalsLock.RLock()
+	defer hornSignalsLock.RUnlock()
+	signal, ok := hornSignals[name]
+	return signal, ok
+}
+
+// HornSignals returns every registered signal, sorted by name.
+func HornSignals() []HornSignal {
+	hornSignalsLock.RLock()
+	defer hornSignalsLock.RUnlock()
+	signals := make([]HornSignal, 0, len(hornSignals))
+	for _, signal := range hornSignals {
+		signals = append(signals, signal)
+	}
+	sort.Slice(signals, func(i, j int) bool {
+		return signals[i].Name < signals[j].Name
+	})
+	return signals
+}
+
+// hornBlast is a single sound of a signal
+type hornBlast struct {
+	long bool
+	// a space came before it
+	pause bool
+}
+
+// ValidateHornPattern checks a pattern written in the mini-notation, see HornSignal.
+func ValidateHornPattern(pattern string) error {
+	_, err := parseHornPattern(pattern)
+	return err
+}
+
+func parseHornPattern(pattern string) ([]hornBlast, error) {
+	var blasts []hornBlast
+	pause := false
+	for i, symbol := range pattern {
+		switch symbol {
+		case '-', '.':
+			blasts = append(blasts, hornBlast{long: symbol == '-', pause: pause && len(blasts) > 0})
+			pause = false
+		case ' ':
+			pause = true
+		default:
+			return nil, fmt.Errorf("invalid horn pattern '%s', unexpected '%c' at '%d', use '-', '.' and ' '", pattern, symbol, i)
+		}
+	}
+	if len(blasts) == 0 {
+		return nil, fmt.Errorf("invalid horn pattern '%s', no sounds", pattern)
+	}
+	return blasts, nil
+}
+
+// Duration is how long the signal takes to sound with timing.
+func (a HornSignal) Duration(timing HornTiming) time.Duration {
+	blasts, err := parseHornPattern(a.Pattern)
+	if err != nil {
+		return 0
+	}
+	total := time.Duration(0)
+	for i, blast := range blasts {
+		total += blast.length(timing)
+		if i > 0 {
+			total += blast.silenceBefore(timing)
+		}
+	}
+	return total
+}
+
+func (a hornBlast) length(timing HornTiming) time.Duration {
+	if a.long {
+		return timing.Long
+	}
+	return timing.Short
+}
+
+func (a hornBlast) silenceBefore(timing HornTiming) time.Duration {
+	if a.pause {
+		return timing.Pause
+	}
+	return timing.Gap
+}
+
+// Play sounds the signal on the engine's horn, returning once it is done. The horn is left off
+// unless another effect is still sounding it, even when ctx is cancelled part way through.
+func (a HornSignal) Play(ctx context.Context, engine *TrainEngine, timing HornTiming) error {
+	return a.play(ctx, SystemClock, timing, func() error {
+		return engine.holdSound(SOUNDTYPE_HORN)
+	}, func() error {
+		return engine.releaseSound(SOUNDTYPE_HORN)
+	})
+}
+
+func (a HornSignal) play(ctx context.Context, clock Clock, timing HornTiming, on func() error, off func() error) error {
+	blasts, err := parseHornPattern(a.Pattern)
+	if err != nil {
+		return err
+	}
+
+	wait := func(length time.Duration) error {
+		timer := clock.NewTimer(length)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+			return nil
+		}
+	}
+
+	for i, blast := range blasts {
+		if i > 0 {
+			err = wait(blast.silenceBefore(timing))
+			if err != nil {
+				return err
+			}
+		}
+
+		// on cleans up after itself when it fails
+		err = on()
+		if err != nil {
+			return err
+		}
+		err = wait(blast.length(timing))
+		offErr := off()
+		if err != nil {
+			return err
+		}
+		if offErr != nil {
+			return offErr
+		}
+	}
+	return nil
+}
+
+func (a *TrainSimulator) HornTiming() HornTiming {
+	a.soundLock.Lock()
+	defer a.soundLock.Unlock()
+	return a.hornTiming
+}
+
+func (a *TrainSimulator) SetHornTiming(timing HornTiming) {
+	a.soundLock.Lock()
+	defer a.soundLock.Unlock()
+	a.hornTiming = timing
+}
+
+// SoundSignal sounds a horn signal with the simulator's horn timing and clock, returning once
+// it is done. It shares the horn with any other effects sounding it.
+func (a *TrainSimulator) SoundSignal(ctx context.Context, signal HornSignal) error {
+	return signal.play(ctx, a.Clock(), a.HornTiming(), func() error {
+		return a.engine.holdSound(SOUNDTYPE_HORN)
+	}, func() error {
+		return a.engine.releaseSound(SOUNDTYPE_HORN)
+	})
+}
+
+// SoundNamedSignal sounds a registered signal, e.g. HORNSIGNAL_GRADE_CROSSING.
+func (a *TrainSimulator) SoundNamedSignal(ctx context.Context, name string) error {
+	signal, ok := LookupHornSignal(name)
+	if !ok {
+		return fmt.Errorf("unknown horn signal '%s'", name)
+	}
+	return a.SoundSignal(ctx, signal)
+}
+
+// SoundPattern sounds a pattern written in the mini-notation, e.g. "-- . --".
+func (a *TrainSimulator) SoundPattern(ctx context.Context, pattern string) error {
+	return a.SoundSignal(ctx, HornSignal{Pattern: pattern})
+}
+
+// soundStandardSignal plays one of the built in signals
+func (a *TrainSimulator) soundStandardSignal(name string) error {
+	return a.SoundNamedSignal(context.Background(), name)
+}
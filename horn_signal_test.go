@@ -0,0 +1,42 @@
+package lionchief
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+var testHornTiming = HornTiming{Long: 3 * time.Millisecond, Short: time.Millisecond, Gap: time.Millisecond, Pause: 2 * time.Millisecond}
+
+func TestHornSignalPlay(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	signal, _ := LookupHornSignal(HORNSIGNAL_ACKNOWLEDGE)
+	err := signal.Play(context.Background(), engine, testHornTiming)
+	if err != nil {
+		t.Fatalf("Play failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true}, protocol.Horn{On: false}, protocol.Horn{On: true}, protocol.Horn{On: false})
+}
+
+func TestHornSignalPlaySharesTheHorn(t *testing.T) {
+	simulator, transport := newTestSimulator(t)
+	horn, err := simulator.HoldHorn(context.Background())
+	if err != nil {
+		t.Fatalf("HoldHorn failed: %v", err)
+	}
+
+	signal, _ := LookupHornSignal(HORNSIGNAL_ACKNOWLEDGE)
+	err = signal.Play(context.Background(), simulator.engine, testHornTiming)
+	if err != nil {
+		t.Fatalf("Play failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true})
+
+	err = horn.Stop()
+	if err != nil {
+		t.Fatalf("stopping the horn failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true}, protocol.Horn{On: false})
+}
@@ -0,0 +1,215 @@
+package lionchief
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"tinygo.org/x/bluetooth"
+)
+
+var (
+	DeviceInformationService = bluetooth.ServiceUUIDDeviceInformation
+	GenericAccessService     = bluetooth.ServiceUUIDGenericAccess
+
+	ErrInfoUnavailable = errors.New("device information is not available")
+)
+
+// CharacteristicReader is implemented by transports that can read arbitrary GATT
+// characteristics, which is what the Device Information Service needs.
+type CharacteristicReader interface {
+	ReadCharacteristic(service bluetooth.UUID, characteristic bluetooth.UUID) ([]byte, error)
+}
+
+// EngineInfo is everything the locomotive reports about itself in the Device Information Service.
+// Fields the train does not expose are left at their zero value.
+type EngineInfo struct {
+	DeviceName               string
+	ManufacturerName         string
+	ModelNumber              string
+	SerialNumber             string
+	FirmwareRevision         string
+	HardwareRevision         string
+	SoftwareRevision         string
+	SystemID                 *SystemID
+	PnPID                    *PnPID
+	RegulatoryCertifications []RegulatoryCertification
+	// Characteristics that could not be read
+	Missing []bluetooth.UUID
+}
+
+// SystemID is the 64 bit System ID, a 40 bit manufacturer defined identifier followed
+// by the 24 bit Organizationally Unique Identifier of the manufacturer.
+type SystemID struct {
+	ManufacturerID uint64
+	OUI            uint32
+}
+
+type VendorIDSource uint8
+
+const (
+	VENDORIDSOURCE_BLUETOOTH_SIG VendorIDSource = 1
+	VENDORIDSOURCE_USB_IF        VendorIDSource = 2
+)
+
+type PnPID struct {
+	VendorIDSource VendorIDSource
+	VendorID       uint16
+	ProductID      uint16
+	ProductVersion uint16
+}
+
+// RegulatoryCertification is a single entry of the IEEE 11073-20601 regulatory certification data list.
+type RegulatoryCertification struct {
+	AuthorizingBody uint8
+	StructureType   uint8
+	Data            []byte
+}
+
+func (a *TrainEngine) Info() (EngineInfo, error) {
+	if a.info == nil {
+		return EngineInfo{}, ErrInfoUnavailable
+	}
+	return *a.info, nil
+}
+
+// readInfo reads and caches the Device Information Service, when the transport can read it
+func (a *TrainEngine) readInfo() {
+	reader, ok := a.transport.(CharacteristicReader)
+	if !ok {
+		a.logger.Println("Transport cannot read device information")
+		return
+	}
+
+	info, err := ReadEngineInfo(reader)
+	if err != nil {
+		a.logger.Printf("Reading device information failed: %v", err)
+		return
+	}
+	if len(info.Missing) > 0 {
+		a.logger.Printf("Device information is missing '%d' characteristics", len(info.Missing))
+	}
+	a.info = &info
+}
+
+// ReadEngineInfo reads every Device Information Service field the train exposes.
+// Missing characteristics are skipped and listed in Missing; malformed ones are an error.
+func ReadEngineInfo(reader CharacteristicReader) (EngineInfo, error) {
+	info := EngineInfo{}
+	found := false
+	read := func(service bluetooth.UUID, characteristic bluetooth.UUID) []byte {
+		value, err := reader.ReadCharacteristic(service, characteristic)
+		if err != nil {
+			info.Missing = append(info.Missing, characteristic)
+			return nil
+		}
+		found = true
+		return value
+	}
+
+	info.DeviceName = parseString(read(GenericAccessService, DeviceName))
+	info.ManufacturerName = parseString(read(DeviceInformationService, ManufacturerName))
+	info.ModelNumber = parseString(read(DeviceInformationService, ModelNumber))
+	info.SerialNumber = parseString(read(DeviceInformationService, SerialNumber))
+	info.FirmwareRevision = parseString(read(DeviceInformationService, FirmwareRevision))
+	info.HardwareRevision = parseString(read(DeviceInformationService, HardwareRevision))
+	info.SoftwareRevision = parseString(read(DeviceInformationService, SoftwareRevision))
+
+	var err error
+	if value := read(DeviceInformationService, SystemId); value != nil {
+		info.SystemID, err = ParseSystemID(value)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	if value := read(DeviceInformationService, PnpId); value != nil {
+		info.PnPID, err = ParsePnPID(value)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	if value := read(DeviceInformationService, RegulatoryCertificationDataList); value != nil {
+		info.RegulatoryCertifications, err = ParseRegulatoryCertifications(value)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	if !found {
+		return info, ErrInfoUnavailable
+	}
+	return info, nil
+}
+
+// parseString trims the padding some firmwares leave on the end of their strings
+func parseString(value []byte) string {
+	return strings.TrimRight(string(value), "\x00 ")
+}
+
+func ParseSystemID(value []byte) (*SystemID, error) {
+	if len(value) != 8 {
+		return nil, fmt.Errorf("invalid system id, expected '8' bytes got '%d'", len(value))
+	}
+	// little endian on the wire, manufacturer id in the low 5 bytes
+	raw := binary.LittleEndian.Uint64(value)
+	return &SystemID{
+		ManufacturerID: raw & 0xFFFFFFFFFF,
+		OUI:            uint32(raw >> 40),
+	}, nil
+}
+
+func ParsePnPID(value []byte) (*PnPID, error) {
+	if len(value) != 7 {
+		return nil, fmt.Errorf("invalid pnp id, expected '7' bytes got '%d'", len(value))
+	}
+	return &PnPID{
+		VendorIDSource: VendorIDSource(value[0]),
+		VendorID:       binary.LittleEndian.Uint16(value[1:3]),
+		ProductID:      binary.LittleEndian.Uint16(value[3:5]),
+		ProductVersion: binary.LittleEndian.Uint16(value[5:7]),
+	}, nil
+}
+
+// ParseRegulatoryCertifications decodes an IEEE 11073-20601 regulatory certification data list.
+// The list is big endian: a count and byte length, followed by that many entries of
+// authorizing body, structure type, data length and data.
+func ParseRegulatoryCertifications(value []byte) ([]RegulatoryCertification, error) {
+	if len(value) < 4 {
+		return nil, fmt.Errorf("invalid regulatory certification list, expected at least '4' bytes got '%d'", len(value))
+	}
+
+	count := int(binary.BigEndian.Uint16(value[0:2]))
+	length := int(binary.BigEndian.Uint16(value[2:4]))
+	body := value[4:]
+	if len(body) != length {
+		return nil, fmt.Errorf("invalid regulatory certification list, header says '%d' bytes but has '%d'", length, len(body))
+	}
+
+	certifications := make([]RegulatoryCertification, 0, count)
+	for i := 0; i < count; i++ {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("invalid regulatory certification '%d', truncated header", i)
+		}
+		dataLength := int(binary.BigEndian.Uint16(body[2:4]))
+		if len(body) < 4+dataLength {
+			return nil, fmt.Errorf("invalid regulatory certification '%d', truncated data", i)
+		}
+
+		data := make([]byte, dataLength)
+		copy(data, body[4:4+dataLength])
+		certifications = append(certifications, RegulatoryCertification{
+			AuthorizingBody: body[0],
+			StructureType:   body[1],
+			Data:            data,
+		})
+		body = body[4+dataLength:]
+	}
+
+	if len(body) != 0 {
+		return nil, fmt.Errorf("invalid regulatory certification list, '%d' trailing bytes", len(body))
+	}
+	return certifications, nil
+}
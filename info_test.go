@@ -0,0 +1,71 @@
+package lionchief
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"tinygo.org/x/bluetooth"
+)
+
+func TestReadEngineInfo(t *testing.T) {
+	transport := NewMemoryTransport()
+	transport.SetCharacteristic(DeviceName, []byte("LC-0-1-0429-754D"))
+	// some firmwares pad their strings
+	transport.SetCharacteristic(ModelNumber, []byte("6-85280\x00\x00"))
+	transport.SetCharacteristic(SystemId, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0xaa, 0xbb, 0xcc})
+	transport.SetCharacteristic(PnpId, []byte{0x01, 0x0d, 0x00, 0x34, 0x12, 0x02, 0x01})
+
+	info, err := ReadEngineInfo(transport)
+	if err != nil {
+		t.Fatalf("ReadEngineInfo failed: %v", err)
+	}
+	if info.DeviceName != "LC-0-1-0429-754D" || info.ModelNumber != "6-85280" {
+		t.Errorf("ReadEngineInfo strings = '%s', '%s'", info.DeviceName, info.ModelNumber)
+	}
+	if info.SystemID == nil || *info.SystemID != (SystemID{ManufacturerID: 0x0504030201, OUI: 0xccbbaa}) {
+		t.Errorf("ReadEngineInfo system id = %+v", info.SystemID)
+	}
+	if info.PnPID == nil || *info.PnPID != (PnPID{VendorIDSource: VENDORIDSOURCE_BLUETOOTH_SIG, VendorID: 0x000d, ProductID: 0x1234, ProductVersion: 0x0102}) {
+		t.Errorf("ReadEngineInfo pnp id = %+v", info.PnPID)
+	}
+
+	missing := []bluetooth.UUID{ManufacturerName, SerialNumber, FirmwareRevision, HardwareRevision, SoftwareRevision, RegulatoryCertificationDataList}
+	if !slices.Equal(info.Missing, missing) {
+		t.Errorf("ReadEngineInfo missing = %v, expected %v", info.Missing, missing)
+	}
+}
+
+func TestReadEngineInfoFails(t *testing.T) {
+	_, err := ReadEngineInfo(NewMemoryTransport())
+	if !errors.Is(err, ErrInfoUnavailable) {
+		t.Errorf("ReadEngineInfo of nothing returned %v, expected %v", err, ErrInfoUnavailable)
+	}
+
+	transport := NewMemoryTransport()
+	transport.SetCharacteristic(SystemId, []byte{0x01, 0x02})
+	_, err = ReadEngineInfo(transport)
+	if err == nil {
+		t.Error("ReadEngineInfo of a short system id did not fail")
+	}
+}
+
+func TestEngineInfo(t *testing.T) {
+	transport := NewMemoryTransport()
+	transport.SetCharacteristic(ModelNumber, []byte("6-85280"))
+	engine := newTestEngineWith(t, transport)
+
+	info, err := engine.Info()
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if info.ModelNumber != "6-85280" || len(info.Missing) != 9 {
+		t.Errorf("Info = model '%s' with '%d' missing, expected '6-85280' with '9'", info.ModelNumber, len(info.Missing))
+	}
+
+	engine, _ = newTestEngine(t)
+	_, err = engine.Info()
+	if !errors.Is(err, ErrInfoUnavailable) {
+		t.Errorf("Info without device information returned %v, expected %v", err, ErrInfoUnavailable)
+	}
+}
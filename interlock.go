@@ -0,0 +1,157 @@
+package lionchief
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+var ErrInterlock = errors.New("command refused by interlock")
+
+// InterlockError says which rule refused a command and why. It matches ErrInterlock with errors.Is.
+type InterlockError struct {
+	Rule    string
+	Command protocol.Command
+	Reason  string
+}
+
+func (a *InterlockError) Error() string {
+	return fmt.Sprintf("%v '%s': %s", ErrInterlock, a.Rule, a.Reason)
+}
+
+func (a *InterlockError) Is(target error) bool {
+	return target == ErrInterlock
+}
+
+// Interlocks are the built in rules every command is checked against before it is written.
+// They are checked against the state the train is actually in when the command goes out, not
+// when it was asked for, so queued commands cannot sneak past them. Emergency stops are never
+// refused.
+type Interlocks struct {
+	// Fastest the train may be going to change direction, negative allows it at any speed
+	MaxReverseSpeed int
+	// Speed cap below the profile's maximum, 0 for none
+	MaxSpeed int
+	// Stop the train and then change direction, rather than refuse the change
+	AutoStopReverse bool
+}
+
+// DefaultInterlocks only allow a change of direction once the train has stopped.
+var DefaultInterlocks = Interlocks{
+	MaxReverseSpeed: 0,
+	MaxSpeed:        0,
+	AutoStopReverse: false,
+}
+
+// InterlockRule is a custom check, Check returns an error (used as the reason) to refuse cmd.
+// state is the train as it is right before cmd would be written.
+type InterlockRule struct {
+	Name  string
+	Check func(state TrainState, cmd protocol.Command) error
+}
+
+const (
+	INTERLOCK_REVERSE   = "reverse"
+	INTERLOCK_MAX_SPEED = "max_speed"
+)
+
+func (a *TrainEngine) Interlocks() Interlocks {
+	a.interlockLock.RLock()
+	defer a.interlockLock.RUnlock()
+	return a.interlocks
+}
+
+func (a *TrainEngine) SetInterlocks(interlocks Interlocks) {
+	a.interlockLock.Lock()
+	defer a.interlockLock.Unlock()
+	a.interlocks = interlocks
+}
+
+// AddInterlock registers a custom rule, replacing any of the same name.
+func (a *TrainEngine) AddInterlock(rule InterlockRule) error {
+	if rule.Name == "" || rule.Check == nil {
+		return fmt.Errorf("interlock rule needs a name and a check")
+	}
+	if rule.Name == INTERLOCK_REVERSE || rule.Name == INTERLOCK_MAX_SPEED {
+		return fmt.Errorf("interlock rule name '%s' is reserved", rule.Name)
+	}
+
+	a.interlockLock.Lock()
+	defer a.interlockLock.Unlock()
+	a.removeInterlock(rule.Name)
+	a.rules = append(a.rules, rule)
+	return nil
+}
+
+func (a *TrainEngine) RemoveInterlock(name string) {
+	a.interlockLock.Lock()
+	defer a.interlockLock.Unlock()
+	a.removeInterlock(name)
+}
+
+// removeInterlock must be called with the interlock lock held
+func (a *TrainEngine) removeInterlock(name string) {
+	for i, rule := range a.rules {
+		if rule.Name == name {
+			a.rules = append(a.rules[:i], a.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// maxSpeed is the fastest the interlocks and profile allow together
+func (a *TrainEngine) maxSpeed() int {
+	limit := a.Profile().Speed.Max
+	interlocks := a.Interlocks()
+	if interlocks.MaxSpeed > 0 {
+		limit = min(limit, interlocks.MaxSpeed)
+	}
+	return limit
+}
+
+// checkInterlocks runs every rule against cmd, reporting whether the train has to be stopped
+// before cmd can go out. Called on the command goroutine only.
+func (a *TrainEngine) checkInterlocks(cmd protocol.Command) (stopFirst bool, err error) {
+	a.stateLock.RLock()
+	state := a.state.clone()
+	a.stateLock.RUnlock()
+
+	a.interlockLock.RLock()
+	interlocks := a.interlocks
+	rules := make([]InterlockRule, len(a.rules))
+	copy(rules, a.rules)
+	a.interlockLock.RUnlock()
+
+	switch c := cmd.(type) {
+	case protocol.SetSpeed:
+		if interlocks.MaxSpeed > 0 && int(c.Speed) > interlocks.MaxSpeed {
+			return false, &InterlockError{
+				Rule:    INTERLOCK_MAX_SPEED,
+				Command: cmd,
+				Reason:  fmt.Sprintf("speed '%d' is above the cap of '%d'", c.Speed, interlocks.MaxSpeed),
+			}
+		}
+	case protocol.SetDirection:
+		changing := (c.Direction == protocol.DirectionReverse) != state.Reverse
+		if changing && interlocks.MaxReverseSpeed >= 0 && state.Speed > interlocks.MaxReverseSpeed {
+			if !interlocks.AutoStopReverse {
+				return false, &InterlockError{
+					Rule:    INTERLOCK_REVERSE,
+					Command: cmd,
+					Reason:  fmt.Sprintf("cannot change direction at speed '%d', must be at most '%d'", state.Speed, interlocks.MaxReverseSpeed),
+				}
+			}
+			stopFirst = true
+			state.Speed = 0
+		}
+	}
+
+	for _, rule := range rules {
+		err = rule.Check(state, cmd)
+		if err != nil {
+			return false, &InterlockError{Rule: rule.Name, Command: cmd, Reason: err.Error()}
+		}
+	}
+	return stopFirst, nil
+}
@@ -0,0 +1,228 @@
+package lionchief
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// refuse is a custom rule check refusing every command matching refused
+func refuse(refused func(state TrainState, cmd protocol.Command) bool) func(TrainState, protocol.Command) error {
+	return func(state TrainState, cmd protocol.Command) error {
+		if refused(state, cmd) {
+			return fmt.Errorf("'%#v' refused at speed '%d'", cmd, state.Speed)
+		}
+		return nil
+	}
+}
+
+func TestInterlocks(t *testing.T) {
+	reverse := protocol.SetDirection{Direction: protocol.DirectionReverse}
+	forward := protocol.SetDirection{Direction: protocol.DirectionForward}
+	stop := protocol.SetSpeed{Speed: 0}
+	for _, test := range []struct {
+		name       string
+		interlocks Interlocks
+		rules      []InterlockRule
+		speed      int
+		call       func(engine *TrainEngine) error
+		// rule expected to refuse the call, empty when it goes out
+		refusedBy string
+		expected  []protocol.Command
+	}{
+		{
+			name:       "reverse while moving",
+			interlocks: DefaultInterlocks,
+			speed:      5,
+			call:       func(a *TrainEngine) error { return a.SetReverse(true) },
+			refusedBy:  INTERLOCK_REVERSE,
+		},
+		{
+			name:       "reverse while stopped",
+			interlocks: DefaultInterlocks,
+			call:       func(a *TrainEngine) error { return a.SetReverse(true) },
+			expected:   []protocol.Command{reverse},
+		},
+		{
+			name:       "same direction while moving",
+			interlocks: DefaultInterlocks,
+			speed:      5,
+			call:       func(a *TrainEngine) error { return a.SetReverse(false) },
+			expected:   []protocol.Command{forward},
+		},
+		{
+			name:       "reverse at the max reverse speed",
+			interlocks: Interlocks{MaxReverseSpeed: 2},
+			speed:      2,
+			call:       func(a *TrainEngine) error { return a.SetReverse(true) },
+			expected:   []protocol.Command{reverse},
+		},
+		{
+			name:       "reverse above the max reverse speed",
+			interlocks: Interlocks{MaxReverseSpeed: 2},
+			speed:      3,
+			call:       func(a *TrainEngine) error { return a.SetReverse(true) },
+			refusedBy:  INTERLOCK_REVERSE,
+		},
+		{
+			name:       "reverse at any speed",
+			interlocks: Interlocks{MaxReverseSpeed: -1},
+			speed:      20,
+			call:       func(a *TrainEngine) error { return a.SetReverse(true) },
+			expected:   []protocol.Command{reverse},
+		},
+		{
+			name:       "auto stop before reversing",
+			interlocks: Interlocks{AutoStopReverse: true},
+			speed:      5,
+			call:       func(a *TrainEngine) error { return a.SetReverse(true) },
+			expected:   []protocol.Command{stop, reverse},
+		},
+		{
+			name:       "auto stop refused by a custom rule",
+			interlocks: Interlocks{AutoStopReverse: true},
+			rules: []InterlockRule{{Name: "keep_moving", Check: refuse(func(state TrainState, cmd protocol.Command) bool {
+				return cmd == stop
+			})}},
+			speed:     5,
+			call:      func(a *TrainEngine) error { return a.SetReverse(true) },
+			refusedBy: "keep_moving",
+		},
+		{
+			name:       "custom rules see the train stopped by an auto stop",
+			interlocks: Interlocks{AutoStopReverse: true},
+			rules: []InterlockRule{{Name: "stopped_to_reverse", Check: refuse(func(state TrainState, cmd protocol.Command) bool {
+				return cmd == reverse && state.Speed != 0
+			})}},
+			speed:    5,
+			call:     func(a *TrainEngine) error { return a.SetReverse(true) },
+			expected: []protocol.Command{stop, reverse},
+		},
+		{
+			name:       "speed at the cap",
+			interlocks: Interlocks{MaxSpeed: 10},
+			call:       func(a *TrainEngine) error { return a.SetSpeed(10) },
+			expected:   []protocol.Command{protocol.SetSpeed{Speed: 10}},
+		},
+		{
+			name:       "speed above the cap",
+			interlocks: Interlocks{MaxSpeed: 10},
+			call:       func(a *TrainEngine) error { return a.SetSpeed(11) },
+			refusedBy:  INTERLOCK_MAX_SPEED,
+		},
+		{
+			name:       "custom rule refusing",
+			interlocks: DefaultInterlocks,
+			rules: []InterlockRule{{Name: "lights_on", Check: refuse(func(state TrainState, cmd protocol.Command) bool {
+				return cmd == protocol.Lights{On: false}
+			})}},
+			call:      func(a *TrainEngine) error { return a.SetLight(false) },
+			refusedBy: "lights_on",
+		},
+		{
+			name:       "custom rule allowing",
+			interlocks: DefaultInterlocks,
+			rules: []InterlockRule{{Name: "lights_on", Check: refuse(func(state TrainState, cmd protocol.Command) bool {
+				return cmd == protocol.Lights{On: false}
+			})}},
+			call:     func(a *TrainEngine) error { return a.SetHorn(true) },
+			expected: []protocol.Command{protocol.Horn{On: true}},
+		},
+		{
+			name:       "emergency stops are never refused",
+			interlocks: DefaultInterlocks,
+			rules: []InterlockRule{{Name: "keep_moving", Check: refuse(func(state TrainState, cmd protocol.Command) bool {
+				return cmd == stop
+			})}},
+			speed:    5,
+			call:     func(a *TrainEngine) error { return a.EmergencyStop() },
+			expected: []protocol.Command{stop},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine, transport := newTestEngine(t, WithInterlocks(test.interlocks))
+			if test.speed > 0 {
+				err := engine.SetSpeed(test.speed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				transport.Reset()
+			}
+			for _, rule := range test.rules {
+				err := engine.AddInterlock(rule)
+				if err != nil {
+					t.Fatalf("AddInterlock failed: %v", err)
+				}
+			}
+
+			err := test.call(engine)
+			if test.refusedBy == "" {
+				if err != nil {
+					t.Fatalf("%s failed: %v", test.name, err)
+				}
+			} else {
+				var interlockErr *InterlockError
+				if !errors.As(err, &interlockErr) || !errors.Is(err, ErrInterlock) {
+					t.Fatalf("%s returned %v, expected an interlock error", test.name, err)
+				}
+				if interlockErr.Rule != test.refusedBy {
+					t.Errorf("refused by '%s', expected '%s'", interlockErr.Rule, test.refusedBy)
+				}
+			}
+			assertFrames(t, transport, test.expected...)
+		})
+	}
+}
+
+func TestInterlockRefusalKeepsState(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	err := engine.SetSpeed(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.SetReverse(true)
+	if !errors.Is(err, ErrInterlock) {
+		t.Fatalf("SetReverse while moving returned %v, expected %v", err, ErrInterlock)
+	}
+	if state := engine.Snapshot(); state.Reverse || state.Speed != 5 {
+		t.Errorf("refused command changed the state to reverse '%v' at speed '%d'", state.Reverse, state.Speed)
+	}
+}
+
+func TestAddInterlock(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	check := refuse(func(state TrainState, cmd protocol.Command) bool { return true })
+	for _, rule := range []InterlockRule{
+		{Name: "", Check: check},
+		{Name: "no_check"},
+		{Name: INTERLOCK_REVERSE, Check: check},
+		{Name: INTERLOCK_MAX_SPEED, Check: check},
+	} {
+		if err := engine.AddInterlock(rule); err == nil {
+			t.Errorf("rule '%s' was accepted", rule.Name)
+		}
+	}
+
+	err := engine.AddInterlock(InterlockRule{Name: "nothing", Check: check})
+	if err != nil {
+		t.Fatalf("AddInterlock failed: %v", err)
+	}
+	if err := engine.SetHorn(true); !errors.Is(err, ErrInterlock) {
+		t.Fatalf("SetHorn with a rule refusing everything returned %v, expected %v", err, ErrInterlock)
+	}
+	// replacing a rule by name
+	err = engine.AddInterlock(InterlockRule{Name: "nothing", Check: refuse(func(state TrainState, cmd protocol.Command) bool { return false })})
+	if err != nil {
+		t.Fatalf("AddInterlock failed: %v", err)
+	}
+	if err := engine.SetHorn(true); err != nil {
+		t.Fatalf("SetHorn once the rule was replaced failed: %v", err)
+	}
+	engine.RemoveInterlock("nothing")
+	if err := engine.SetHorn(false); err != nil {
+		t.Fatalf("SetHorn once the rule was removed failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true}, protocol.Horn{On: false})
+}
@@ -0,0 +1,313 @@
+package lionchief
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Note is a single note of a melody, Key is the MIDI note number (60 is middle C) or
+// negative for a rest.
+type Note struct {
+	Key      int
+	Duration time.Duration
+}
+
+func (a Note) Rest() bool {
+	return a.Key < 0
+}
+
+type Melody struct {
+	Name  string
+	Notes []Note
+}
+
+func (a Melody) Duration() time.Duration {
+	total := time.Duration(0)
+	for _, note := range a.Notes {
+		total += note.Duration
+	}
+	return total
+}
+
+// MelodyOptions controls how a melody is fitted to the horn's five pitches and played.
+type MelodyOptions struct {
+	// Semitones between neighbouring horn pitches
+	PitchStep int
+	// Shift the melody so the middle of its range plays at SOUNDPITCH_NORMAL, otherwise
+	// NormalKey does
+	AutoCenter bool
+	NormalKey  int
+	// Silence left at the end of each note so repeated notes are heard apart, 0 slurs them
+	Articulation time.Duration
+}
+
+var DefaultMelodyOptions = MelodyOptions{
+	PitchStep:    2,
+	AutoCenter:   true,
+	NormalKey:    60,
+	Articulation: 40 * time.Millisecond,
+}
+
+var hornPitches = []SoundPitch{
+	SoundPitch(SOUNDPITCH_LOWEST),
+	SoundPitch(SOUNDPITCH_LOW),
+	SoundPitch(SOUNDPITCH_NORMAL),
+	SoundPitch(SOUNDPITCH_HIGH),
+	SoundPitch(SOUNDPITCH_HIGHEST),
+}
+
+// HornPitches maps every note to the nearest of the horn's pitches, rests map to SOUNDPITCH_NORMAL.
+func (a Melody) HornPitches(options MelodyOptions) []SoundPitch {
+	normal := options.NormalKey
+	if options.AutoCenter {
+		lowest, highest := math.MaxInt, math.MinInt
+		for _, note := range a.Notes {
+			if !note.Rest() {
+				lowest, highest = min(lowest, note.Key), max(highest, note.Key)
+			}
+		}
+		if lowest <= highest {
+			normal = (lowest + highest) / 2
+		}
+	}
+	step := max(options.PitchStep, 1)
+
+	pitches := make([]SoundPitch, len(a.Notes))
+	for i, note := range a.Notes {
+		pitches[i] = SoundPitch(SOUNDPITCH_NORMAL)
+		if note.Rest() {
+			continue
+		}
+		offset := int(math.Round(float64(note.Key-normal) / float64(step)))
+		offset = max(-2, min(2, offset))
+		pitches[i] = hornPitches[offset+2]
+	}
+	return pitches
+}
+
+// LoadMelody reads a melody from a MIDI file (.mid or .midi) or the text format, see ParseMelody.
+func LoadMelody(path string) (Melody, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Melody{}, err
+	}
+
+	var melody Melody
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mid", ".midi":
+		melody, err = ParseMIDI(data)
+	default:
+		melody, err = ParseMelody(string(data))
+	}
+	if err != nil {
+		return Melody{}, fmt.Errorf("failed to load melody '%s': %w", path, err)
+	}
+	if melody.Name == "" {
+		melody.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return melody, nil
+}
+
+// ParseMelody reads the text melody format: whitespace separated notes written as a name,
+// optional sharp or flat and octave ('C4', 'F#3', 'Bb4') or 'R' for a rest, each optionally
+// followed by ':' and its length in beats (default 1). 'tempo <bpm>' sets the beats per minute
+// (default 120) for the notes after it, 'name <text>' names the melody and a '#' at the start of a
+// field starts a comment.
+//
+//	name Twinkle
+//	tempo 100
+//	C4 C4 G4 G4 A4 A4 G4:2
+func ParseMelody(text string) (Melody, error) {
+	melody := Melody{}
+	tempo := 120.0
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		content := stripComment(scanner.Text())
+		fields := strings.Fields(content)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "name":
+			melody.Name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(content), fields[0]))
+			continue
+		case "tempo":
+			if len(fields) != 2 {
+				return melody, fmt.Errorf("line %d: expected 'tempo <bpm>'", line)
+			}
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || value <= 0 {
+				return melody, fmt.Errorf("line %d: invalid tempo '%s'", line, fields[1])
+			}
+			tempo = value
+			continue
+		}
+
+		for _, field := range fields {
+			note, err := parseNote(field, tempo)
+			if err != nil {
+				return melody, fmt.Errorf("line %d: %w", line, err)
+			}
+			melody.Notes = append(melody.Notes, note)
+		}
+	}
+	if len(melody.Notes) == 0 {
+		return melody, fmt.Errorf("melody has no notes")
+	}
+	return melody, nil
+}
+
+// stripComment cuts line at the first '#' starting a field, leaving sharps in notes alone
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' && (i == 0 || unicode.IsSpace(rune(line[i-1]))) {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+var noteSemitones = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+func parseNote(field string, tempo float64) (Note, error) {
+	name, length, hasLength := strings.Cut(field, ":")
+	beats := 1.0
+	if hasLength {
+		value, err := strconv.ParseFloat(length, 64)
+		if err != nil || value <= 0 {
+			return Note{}, fmt.Errorf("invalid note length '%s' in '%s'", length, field)
+		}
+		beats = value
+	}
+	duration := time.Duration(beats * 60 / tempo * float64(time.Second))
+
+	if strings.EqualFold(name, "R") {
+		return Note{Key: -1, Duration: duration}, nil
+	}
+
+	upper := strings.ToUpper(name)
+	if upper == "" {
+		return Note{}, fmt.Errorf("invalid note '%s'", field)
+	}
+	semitone, ok := noteSemitones[upper[0]]
+	if !ok {
+		return Note{}, fmt.Errorf("invalid note '%s', must start with A to G or be R", field)
+	}
+	rest := name[1:]
+	if strings.HasPrefix(rest, "#") {
+		semitone++
+		rest = rest[1:]
+	} else if strings.HasPrefix(rest, "b") {
+		semitone--
+		rest = rest[1:]
+	}
+	octave, err := strconv.Atoi(rest)
+	if err != nil || octave < -1 || octave > 9 {
+		return Note{}, fmt.Errorf("invalid octave in note '%s'", field)
+	}
+	// the flats and sharps at either end fall outside the MIDI notes, e.g. 'Cb-1'
+	key := (octave+1)*12 + semitone
+	if key < 0 || key > 127 {
+		return Note{}, fmt.Errorf("invalid note '%s', must be between 'C-1' and 'G9'", field)
+	}
+	return Note{Key: key, Duration: duration}, nil
+}
+
+// scheduledCommand is a horn or pitch change due at a time from the start of the melody
+type scheduledCommand struct {
+	at     time.Duration
+	action func() error
+}
+
+// PlayMelody plays melody on the horn, returning once it is done. The horn is left off and at
+// the pitch it was at before, even when ctx is cancelled part way through. Each command is sent
+// as far ahead of its time as the one before took to write, so the notes keep to the beat
+// however slow the link is.
+func (a *TrainSimulator) PlayMelody(ctx context.Context, melody Melody, options MelodyOptions) (err error) {
+	if len(melody.Notes) == 0 {
+		return fmt.Errorf("melody '%s' has no notes", melody.Name)
+	}
+	originalPitch := a.engine.Snapshot().PitchHorn
+
+	sounding := false
+	defer func() {
+		var releaseErr error
+		if sounding {
+			releaseErr = a.engine.releaseSound(SOUNDTYPE_HORN)
+		}
+		err = errors.Join(err, releaseErr, a.engine.SetHornPitch(originalPitch))
+	}()
+	on := func() error {
+		if sounding {
+			return nil
+		}
+		err := a.engine.holdSound(SOUNDTYPE_HORN)
+		sounding = err == nil
+		return err
+	}
+	off := func() error {
+		if !sounding {
+			return nil
+		}
+		sounding = false
+		return a.engine.releaseSound(SOUNDTYPE_HORN)
+	}
+
+	pitches := melody.HornPitches(options)
+	var commands []scheduledCommand
+	start := time.Duration(0)
+	for i, note := range melody.Notes {
+		if !note.Rest() {
+			pitch := pitches[i]
+			commands = append(commands,
+				scheduledCommand{at: start, action: func() error { return a.engine.SetHornPitch(pitch) }},
+				scheduledCommand{at: start, action: on},
+			)
+			end := start + note.Duration
+			slurred := options.Articulation == 0 && i+1 < len(melody.Notes) && !melody.Notes[i+1].Rest()
+			if !slurred {
+				commands = append(commands, scheduledCommand{at: max(start, end-options.Articulation), action: off})
+			}
+		}
+		start += note.Duration
+	}
+	commands = append(commands, scheduledCommand{at: start, action: off})
+	// stable, so a pitch change always goes out before the horn it is for
+	sort.SliceStable(commands, func(i, j int) bool {
+		return commands[i].at < commands[j].at
+	})
+
+	clock := a.Clock()
+	began := clock.Now()
+	lag := time.Duration(0)
+	for _, command := range commands {
+		due := began.Add(command.at - lag)
+		if wait := due.Sub(clock.Now()); wait > 0 {
+			timer := clock.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C():
+			}
+		}
+		sent := clock.Now()
+		err := command.action()
+		if err != nil {
+			return err
+		}
+		lag = clock.Now().Sub(sent)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func TestParseNoteRange(t *testing.T) {
+	for _, test := range []struct {
+		note string
+		key  int
+		ok   bool
+	}{
+		{"C4", 60, true},
+		{"A4", 69, true},
+		{"C-1", 0, true},
+		{"G9", 127, true},
+		{"Cb-1", 0, false},
+		{"G#9", 0, false},
+		{"B#9", 0, false},
+		{"C10", 0, false},
+	} {
+		note, err := parseNote(test.note, 120)
+		switch {
+		case test.ok && err != nil:
+			t.Errorf("parseNote('%s') failed: %v", test.note, err)
+		case test.ok && note.Key != test.key:
+			t.Errorf("parseNote('%s') key = '%d', expected '%d'", test.note, note.Key, test.key)
+		case !test.ok && err == nil:
+			t.Errorf("parseNote('%s') = key '%d', expected an error", test.note, note.Key)
+		}
+	}
+}
+
+func TestParseMelody(t *testing.T) {
+	melody, err := ParseMelody(`
+# a comment on its own
+name Scale test
+C4 D4:2 # two beats
+tempo 60
+R:0.5 F#3 Bb4
+`)
+	if err != nil {
+		t.Fatalf("ParseMelody failed: %v", err)
+	}
+	expected := Melody{Name: "Scale test", Notes: []Note{
+		{Key: 60, Duration: 500 * time.Millisecond},
+		{Key: 62, Duration: time.Second},
+		{Key: -1, Duration: 500 * time.Millisecond},
+		{Key: 54, Duration: time.Second},
+		{Key: 70, Duration: time.Second},
+	}}
+	if melody.Name != expected.Name || !slices.Equal(melody.Notes, expected.Notes) {
+		t.Errorf("ParseMelody returned '%+v', expected '%+v'", melody, expected)
+	}
+	if duration := melody.Duration(); duration != 4*time.Second {
+		t.Errorf("melody lasts '%v', expected '4s'", duration)
+	}
+}
+
+func TestParseMelodyInvalid(t *testing.T) {
+	for _, test := range []struct {
+		reason string
+		text   string
+	}{
+		{"no notes", "name Silence\ntempo 90"},
+		{"tempo without bpm", "tempo\nC4"},
+		{"non numeric tempo", "tempo fast\nC4"},
+		{"negative tempo", "tempo -60\nC4"},
+		{"unknown note", "C4 H4"},
+		{"missing octave", "C"},
+		{"non numeric length", "C4:long"},
+		{"zero length", "C4:0"},
+		{"note out of range", "B#9"},
+	} {
+		t.Run(test.reason, func(t *testing.T) {
+			if _, err := ParseMelody(test.text); err == nil {
+				t.Errorf("ParseMelody of '%s' succeeded", test.text)
+			}
+		})
+	}
+}
+
+var testMelodyOptions = MelodyOptions{PitchStep: 2, NormalKey: 60, Articulation: 100 * time.Millisecond}
+
+// testMelody is C4 D4 R C4 at 120 bpm, half a second a note
+var testMelody = Melody{Name: "test", Notes: []Note{
+	{Key: 60, Duration: 500 * time.Millisecond},
+	{Key: 62, Duration: 500 * time.Millisecond},
+	{Key: -1, Duration: 500 * time.Millisecond},
+	{Key: 60, Duration: 500 * time.Millisecond},
+}}
+
+func TestPlayMelody(t *testing.T) {
+	simulator, transport := newTestSimulator(t)
+	clock := rampClock{NewVirtualClock(time.Unix(0, 0)), time.Unix(0, 0)}
+	simulator.SetClock(clock)
+
+	result := startRamp(func() error { return simulator.PlayMelody(context.Background(), testMelody, testMelodyOptions) })
+	// each note stops short by the articulation, the last command is the final horn off
+	for _, due := range []time.Duration{400 * time.Millisecond, 500 * time.Millisecond, 900 * time.Millisecond, 1500 * time.Millisecond, 1900 * time.Millisecond, 2 * time.Second} {
+		clock.step(t, due)
+	}
+	if err := waitRamp(t, result); err != nil {
+		t.Fatalf("PlayMelody failed: %v", err)
+	}
+
+	normal := protocol.SetSoundPitch{Type: protocol.SoundHorn, Pitch: protocol.PitchNormal}
+	high := protocol.SetSoundPitch{Type: protocol.SoundHorn, Pitch: protocol.PitchHigh}
+	on, off := protocol.Horn{On: true}, protocol.Horn{On: false}
+	assertFrames(t, transport, normal, on, off, high, on, off, normal, on, off, normal)
+}
+
+// slowTransport takes a while, on a VirtualClock, to write each frame
+type slowTransport struct {
+	*MemoryTransport
+	clock *VirtualClock
+}
+
+func (a *slowTransport) WriteFrame(frame []byte) error {
+	a.clock.Advance(20 * time.Millisecond)
+	return a.MemoryTransport.WriteFrame(frame)
+}
+
+func TestPlayMelodyMakesUpForSlowWrites(t *testing.T) {
+	virtual := NewVirtualClock(time.Unix(0, 0))
+	transport := &slowTransport{MemoryTransport: NewMemoryTransport(), clock: virtual}
+	simulator := NewSimulatorWithEngine(newTestEngineWith(t, transport))
+	clock := rampClock{virtual, virtual.Now()}
+	simulator.SetClock(clock)
+
+	result := startRamp(func() error { return simulator.PlayMelody(context.Background(), testMelody, testMelodyOptions) })
+	// every command goes out as early as the one before took to write
+	for _, due := range []time.Duration{380 * time.Millisecond, 480 * time.Millisecond, 880 * time.Millisecond, 1480 * time.Millisecond, 1880 * time.Millisecond, 1980 * time.Millisecond} {
+		clock.step(t, due)
+	}
+	if err := waitRamp(t, result); err != nil {
+		t.Fatalf("PlayMelody failed: %v", err)
+	}
+}
+
+func TestPlayMelodyCancelled(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		failWrite error
+	}{
+		{"cleans up", nil},
+		{"reports failing to clean up", errors.New("link lost")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			simulator, transport := newTestSimulator(t)
+			clock := NewVirtualClock(time.Unix(0, 0))
+			simulator.SetClock(clock)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			result := startRamp(func() error { return simulator.PlayMelody(ctx, testMelody, testMelodyOptions) })
+			// the first note is sounding
+			clock.BlockUntil(1)
+			transport.FailWrites(test.failWrite)
+			cancel()
+			err := waitRamp(t, result)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("cancelled PlayMelody returned %v, expected %v", err, context.Canceled)
+			}
+			if test.failWrite != nil {
+				if !errors.Is(err, test.failWrite) {
+					t.Fatalf("PlayMelody returned %v, expected it to report %v", err, test.failWrite)
+				}
+				return
+			}
+			normal := protocol.SetSoundPitch{Type: protocol.SoundHorn, Pitch: protocol.PitchNormal}
+			assertFrames(t, transport, normal, protocol.Horn{On: true}, protocol.Horn{On: false}, normal)
+		})
+	}
+}
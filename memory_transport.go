@@ -0,0 +1,181 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jasper-186/lionchief/protocol"
+	"tinygo.org/x/bluetooth"
+)
+
+// MemoryTransport is an in-memory Transport that records every frame written to it.
+// It lets code built on TrainEngine be exercised without a locomotive on the desk,
+// by asserting the exact frames that were sent and by injecting notifications and
+// connection drops.
+type MemoryTransport struct {
+	lock      sync.Mutex
+	frames    [][]byte
+	handlers  []func(frame []byte)
+	events    chan bool
+	writeErr  error
+	closed    bool
+	connected bool
+	values    map[bluetooth.UUID][]byte
+	// number of upcoming reconnects that should fail
+	reconnectFailures int
+	reconnects        int
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		events:    make(chan bool, 16),
+		connected: true,
+		values:    map[bluetooth.UUID][]byte{},
+	}
+}
+
+func (a *MemoryTransport) WriteFrame(frame []byte) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return errors.New("transport is closed")
+	}
+	if !a.connected {
+		return errors.New("transport is disconnected")
+	}
+	if a.writeErr != nil {
+		return a.writeErr
+	}
+
+	recorded := make([]byte, len(frame))
+	copy(recorded, frame)
+	a.frames = append(a.frames, recorded)
+
+	// like the real train, hang up when told to
+	if cmd, err := protocol.Unmarshal(frame); err == nil && cmd.Opcode() == protocol.OpDisconnect {
+		a.connected = false
+		select {
+		case a.events <- false:
+		default:
+		}
+	}
+	return nil
+}
+
+func (a *MemoryTransport) Subscribe(handler func(frame []byte)) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.handlers = append(a.handlers, handler)
+	return nil
+}
+
+func (a *MemoryTransport) ConnectionEvents() <-chan bool {
+	return a.events
+}
+
+func (a *MemoryTransport) Reconnect(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.reconnects++
+	if a.closed {
+		return errors.New("transport is closed")
+	}
+	if a.reconnectFailures > 0 {
+		a.reconnectFailures--
+		return errors.New("reconnect failed")
+	}
+	a.connected = true
+	return nil
+}
+
+func (a *MemoryTransport) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.closed = true
+	a.connected = false
+	return nil
+}
+
+// Frames returns a copy of every frame written so far, oldest first.
+func (a *MemoryTransport) Frames() [][]byte {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	frames := make([][]byte, len(a.frames))
+	for i, frame := range a.frames {
+		frames[i] = make([]byte, len(frame))
+		copy(frames[i], frame)
+	}
+	return frames
+}
+
+// Reset forgets every recorded frame.
+func (a *MemoryTransport) Reset() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.frames = nil
+}
+
+// FailWrites makes every following write return err, pass nil to recover.
+func (a *MemoryTransport) FailWrites(err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.writeErr = err
+}
+
+// Notify delivers frame to every subscriber as if the train had sent it.
+func (a *MemoryTransport) Notify(frame []byte) {
+	a.lock.Lock()
+	handlers := make([]func(frame []byte), len(a.handlers))
+	copy(handlers, a.handlers)
+	a.lock.Unlock()
+
+	for _, handler := range handlers {
+		handler(frame)
+	}
+}
+
+// SetConnected simulates the link going down (false) or coming back (true).
+func (a *MemoryTransport) SetConnected(connected bool) {
+	a.lock.Lock()
+	a.connected = connected
+	a.lock.Unlock()
+	a.events <- connected
+}
+
+// SetCharacteristic sets the value returned when characteristic is read, e.g. to fake
+// the Device Information Service.
+func (a *MemoryTransport) SetCharacteristic(characteristic bluetooth.UUID, value []byte) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.values[characteristic] = value
+}
+
+func (a *MemoryTransport) ReadCharacteristic(service bluetooth.UUID, characteristic bluetooth.UUID) ([]byte, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	value, ok := a.values[characteristic]
+	if !ok {
+		return nil, fmt.Errorf("characteristic '%v' not found", characteristic.String())
+	}
+	return value, nil
+}
+
+// FailReconnects makes the next count reconnect attempts fail.
+func (a *MemoryTransport) FailReconnects(count int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.reconnectFailures = count
+}
+
+// Reconnects is how many times a reconnect has been attempted.
+func (a *MemoryTransport) Reconnects() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.reconnects
+}
@@ -0,0 +1,274 @@
+package lionchief
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+var ErrInvalidMIDI = errors.New("invalid midi file")
+
+// midiEvent is a note starting or stopping, or a tempo change, at an absolute tick
+type midiEvent struct {
+	tick  uint64
+	kind  int
+	key   int
+	tempo uint32
+}
+
+// in the order they are applied when they share a tick: the tempo first, then notes
+// stopping before notes starting
+const (
+	midiTempo = iota
+	midiNoteOff
+	midiNoteOn
+)
+
+// ParseMIDI reads a standard MIDI file (format 0 or 1) into a melody. The horn can only play
+// one note at a time, so where notes overlap the highest one sounding wins.
+func ParseMIDI(data []byte) (Melody, error) {
+	chunkType, header, data, err := readMIDIChunk(data)
+	if err != nil {
+		return Melody{}, err
+	}
+	if chunkType != "MThd" || len(header) < 6 {
+		return Melody{}, fmt.Errorf("%w, missing header", ErrInvalidMIDI)
+	}
+	format := binary.BigEndian.Uint16(header[0:2])
+	tracks := int(binary.BigEndian.Uint16(header[2:4]))
+	division := binary.BigEndian.Uint16(header[4:6])
+	if format > 1 {
+		return Melody{}, fmt.Errorf("%w, format '%d' is not supported", ErrInvalidMIDI, format)
+	}
+	if division == 0 {
+		return Melody{}, fmt.Errorf("%w, zero ticks per beat", ErrInvalidMIDI)
+	}
+	if division&0x8000 != 0 {
+		return Melody{}, fmt.Errorf("%w, SMPTE timing is not supported", ErrInvalidMIDI)
+	}
+
+	var events []midiEvent
+	for track := 0; track < tracks; track++ {
+		var body []byte
+		chunkType, body, data, err = readMIDIChunk(data)
+		if err != nil {
+			return Melody{}, err
+		}
+		if chunkType != "MTrk" {
+			// unknown chunks are to be skipped
+			track--
+			continue
+		}
+		trackEvents, err := parseMIDITrack(body)
+		if err != nil {
+			return Melody{}, fmt.Errorf("track '%d': %w", track, err)
+		}
+		events = append(events, trackEvents...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].tick != events[j].tick {
+			return events[i].tick < events[j].tick
+		}
+		return events[i].kind < events[j].kind
+	})
+
+	return midiMelody(events, uint64(division))
+}
+
+func readMIDIChunk(data []byte) (string, []byte, []byte, error) {
+	if len(data) < 8 {
+		return "", nil, nil, fmt.Errorf("%w, truncated chunk", ErrInvalidMIDI)
+	}
+	length := binary.BigEndian.Uint32(data[4:8])
+	if uint64(len(data)-8) < uint64(length) {
+		return "", nil, nil, fmt.Errorf("%w, truncated '%s' chunk", ErrInvalidMIDI, data[0:4])
+	}
+	return string(data[0:4]), data[8 : 8+length], data[8+length:], nil
+}
+
+func readVarLen(data []byte, position int) (uint64, int, error) {
+	value := uint64(0)
+	for i := 0; i < 4; i++ {
+		if position >= len(data) {
+			return 0, position, fmt.Errorf("%w, truncated variable length value", ErrInvalidMIDI)
+		}
+		b := data[position]
+		position++
+		value = value<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return value, position, nil
+		}
+	}
+	return 0, position, fmt.Errorf("%w, variable length value too long", ErrInvalidMIDI)
+}
+
+func parseMIDITrack(data []byte) ([]midiEvent, error) {
+	var events []midiEvent
+	tick := uint64(0)
+	status := byte(0)
+	position := 0
+	for position < len(data) {
+		delta, next, err := readVarLen(data, position)
+		if err != nil {
+			return nil, err
+		}
+		position = next
+		tick += delta
+		if position >= len(data) {
+			return nil, fmt.Errorf("%w, truncated event", ErrInvalidMIDI)
+		}
+
+		if data[position]&0x80 != 0 {
+			status = data[position]
+			position++
+		} else if status == 0 {
+			return nil, fmt.Errorf("%w, running status without a status", ErrInvalidMIDI)
+		}
+
+		switch {
+		case status == 0xff:
+			if position >= len(data) {
+				return nil, fmt.Errorf("%w, truncated meta event", ErrInvalidMIDI)
+			}
+			metaType := data[position]
+			length, next, err := readVarLen(data, position+1)
+			if err != nil {
+				return nil, err
+			}
+			if uint64(len(data)-next) < length {
+				return nil, fmt.Errorf("%w, truncated meta event", ErrInvalidMIDI)
+			}
+			value := data[next : next+int(length)]
+			position = next + int(length)
+			if metaType == 0x51 && len(value) == 3 {
+				tempo := uint32(value[0])<<16 | uint32(value[1])<<8 | uint32(value[2])
+				events = append(events, midiEvent{tick: tick, kind: midiTempo, tempo: tempo})
+			}
+			if metaType == 0x2f {
+				return events, nil
+			}
+			// meta and sysex events cancel running status
+			status = 0
+		case status == 0xf0 || status == 0xf7:
+			length, next, err := readVarLen(data, position)
+			if err != nil {
+				return nil, err
+			}
+			if uint64(len(data)-next) < length {
+				return nil, fmt.Errorf("%w, truncated sysex event", ErrInvalidMIDI)
+			}
+			position = next + int(length)
+			status = 0
+		default:
+			size := 2
+			if kind := status & 0xf0; kind == 0xc0 || kind == 0xd0 {
+				size = 1
+			}
+			if position+size > len(data) {
+				return nil, fmt.Errorf("%w, truncated channel event", ErrInvalidMIDI)
+			}
+			key, velocity := int(data[position]), 0
+			if size == 2 {
+				velocity = int(data[position+1])
+			}
+			position += size
+
+			// channel 10 is percussion, there is no pitch to play on the horn
+			if status&0x0f == 9 {
+				continue
+			}
+			switch status & 0xf0 {
+			case 0x90:
+				kind := midiNoteOn
+				if velocity == 0 {
+					kind = midiNoteOff
+				}
+				events = append(events, midiEvent{tick: tick, kind: kind, key: key})
+			case 0x80:
+				events = append(events, midiEvent{tick: tick, kind: midiNoteOff, key: key})
+			}
+		}
+	}
+	return events, nil
+}
+
+// midiDuration converts ticks at tempo (microseconds per beat) into a duration, failing
+// rather than overflowing
+func midiDuration(ticks uint64, tempo uint32, ticksPerBeat uint64) (time.Duration, error) {
+	high, low := bits.Mul64(ticks, uint64(tempo)*uint64(time.Microsecond))
+	if high >= ticksPerBeat {
+		return 0, fmt.Errorf("'%d' ticks overflow a duration", ticks)
+	}
+	nanoseconds, _ := bits.Div64(high, low, ticksPerBeat)
+	if nanoseconds > math.MaxInt64 {
+		return 0, fmt.Errorf("'%d' ticks overflow a duration", ticks)
+	}
+	return time.Duration(nanoseconds), nil
+}
+
+// midiMelody turns note events into a single line of notes, the highest sounding note at
+// any time, and ticks into durations following the tempo changes
+func midiMelody(events []midiEvent, ticksPerBeat uint64) (Melody, error) {
+	melody := Melody{}
+	// microseconds per beat, 120 bpm until told otherwise
+	tempo := uint32(500000)
+	sounding := map[int]int{}
+	lastTick := uint64(0)
+	current := -1
+	var length time.Duration
+
+	highest := func() int {
+		key := -1
+		for k, count := range sounding {
+			if count > 0 && k > key {
+				key = k
+			}
+		}
+		return key
+	}
+
+	for _, event := range events {
+		if event.tick > lastTick {
+			elapsed, err := midiDuration(event.tick-lastTick, tempo, ticksPerBeat)
+			if err != nil {
+				return Melody{}, fmt.Errorf("%w, %w", ErrInvalidMIDI, err)
+			}
+			if elapsed > math.MaxInt64-length {
+				return Melody{}, fmt.Errorf("%w, note at tick '%d' is too long", ErrInvalidMIDI, event.tick)
+			}
+			length += elapsed
+			lastTick = event.tick
+		}
+
+		switch event.kind {
+		case midiTempo:
+			tempo = event.tempo
+			continue
+		case midiNoteOn:
+			sounding[event.key]++
+		case midiNoteOff:
+			if sounding[event.key] > 0 {
+				sounding[event.key]--
+			}
+		}
+
+		key := highest()
+		if key == current {
+			continue
+		}
+		if length > 0 && (current >= 0 || len(melody.Notes) > 0) {
+			melody.Notes = append(melody.Notes, Note{Key: current, Duration: length})
+		}
+		current = key
+		length = 0
+	}
+
+	if len(melody.Notes) == 0 {
+		return melody, fmt.Errorf("%w, no notes", ErrInvalidMIDI)
+	}
+	return melody, nil
+}
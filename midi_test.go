@@ -0,0 +1,59 @@
+package lionchief
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// testMIDI builds a format 0 file holding a single track
+func testMIDI(division uint16, track ...byte) []byte {
+	data := []byte{'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, byte(division >> 8), byte(division)}
+	length := len(track)
+	data = append(data, 'M', 'T', 'r', 'k', byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	return append(data, track...)
+}
+
+func TestParseMIDISkipsDrums(t *testing.T) {
+	melody, err := ParseMIDI(testMIDI(480,
+		// a high drum on channel 10 over middle C for a beat
+		0x00, 0x99, 0x51, 0x64,
+		0x00, 0x90, 0x3c, 0x64,
+		0x83, 0x60, 0x80, 0x3c, 0x00,
+		0x00, 0x89, 0x51, 0x00,
+		0x00, 0xff, 0x2f, 0x00,
+	))
+	if err != nil {
+		t.Fatalf("ParseMIDI failed: %v", err)
+	}
+	if len(melody.Notes) != 1 || melody.Notes[0] != (Note{Key: 60, Duration: 500 * time.Millisecond}) {
+		t.Fatalf("ParseMIDI notes = %v, expected only middle C for 500ms", melody.Notes)
+	}
+}
+
+func TestParseMIDIOverflow(t *testing.T) {
+	// the slowest tempo, a tick a beat and a note held for the longest delta three times over
+	_, err := ParseMIDI(testMIDI(1,
+		0x00, 0xff, 0x51, 0x03, 0xff, 0xff, 0xff,
+		0x00, 0x90, 0x3c, 0x64,
+		0xff, 0xff, 0xff, 0x7f, 0x90, 0x32, 0x64,
+		0xff, 0xff, 0xff, 0x7f, 0x90, 0x28, 0x64,
+		0xff, 0xff, 0xff, 0x7f, 0x80, 0x3c, 0x00,
+		0x00, 0xff, 0x2f, 0x00,
+	))
+	if !errors.Is(err, ErrInvalidMIDI) {
+		t.Fatalf("ParseMIDI of an overlong note returned %v, expected %v", err, ErrInvalidMIDI)
+	}
+}
+
+func TestMIDIDuration(t *testing.T) {
+	duration, err := midiDuration(960, 500000, 480)
+	if err != nil || duration != time.Second {
+		t.Errorf("midiDuration of two beats = '%v', %v, expected '1s'", duration, err)
+	}
+	_, err = midiDuration(math.MaxUint64, 0xffffff, 1)
+	if err == nil {
+		t.Error("midiDuration of the most ticks did not fail")
+	}
+}
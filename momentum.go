@@ -0,0 +1,213 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var ErrRampSuperseded = errors.New("speed ramp superseded by a new target")
+
+// Easing shapes how a ramp spreads its speed steps over its duration.
+type Easing int
+
+const (
+	// The same time between every step
+	EASING_LINEAR Easing = iota
+	// Gentle at both ends, quickest in the middle
+	EASING_EASE_IN_OUT
+	// Quick at first, creeping up on the target
+	EASING_EXPONENTIAL
+)
+
+func (a Easing) String() string {
+	switch a {
+	case EASING_LINEAR:
+		return "Linear"
+	case EASING_EASE_IN_OUT:
+		return "EaseInOut"
+	case EASING_EXPONENTIAL:
+		return "Exponential"
+	}
+	return "Unknown"
+}
+
+// apply maps progress through the ramp's duration to progress through its speed change, both 0 to 1
+func (a Easing) apply(progress float64) float64 {
+	switch a {
+	case EASING_EASE_IN_OUT:
+		return (1 - math.Cos(math.Pi*progress)) / 2
+	case EASING_EXPONENTIAL:
+		return (1 - math.Pow(2, -10*progress)) / (1 - math.Pow(2, -10))
+	}
+	return progress
+}
+
+// at is the progress through the duration at which the ramp has made the given fraction of its change
+func (a Easing) at(change float64) float64 {
+	low, high := 0.0, 1.0
+	for i := 0; i < 32; i++ {
+		middle := (low + high) / 2
+		if a.apply(middle) < change {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return high
+}
+
+// Momentum is how quickly the simulator changes speed, in speed steps per second. A rate of
+// 0 changes speed as fast as the engine will send the commands.
+type Momentum struct {
+	Acceleration float64
+	Deceleration float64
+	// Rate used by Brake
+	BrakeRate float64
+	Easing    Easing
+	// Engine volume to go with each speed, nil leaves the engine volume alone
+	EngineVolume func(speed int) int
+	// Clock to time ramps by, the simulator's when nil
+	Clock Clock
+}
+
+var DefaultMomentum = Momentum{
+	Acceleration: 4,
+	Deceleration: 6,
+	BrakeRate:    15,
+	Easing:       EASING_LINEAR,
+	EngineVolume: DefaultEngineVolume,
+}
+
+// DefaultEngineVolume gets a notch louder every three speed steps.
+func DefaultEngineVolume(speed int) int {
+	return int(math.Ceil(float64(speed) / 3))
+}
+
+func (a *TrainSimulator) Momentum() Momentum {
+	a.rampLock.Lock()
+	defer a.rampLock.Unlock()
+	return a.momentum
+}
+
+func (a *TrainSimulator) SetMomentum(momentum Momentum) error {
+	if momentum.Acceleration < 0 || momentum.Deceleration < 0 || momentum.BrakeRate < 0 {
+		return fmt.Errorf("invalid momentum, rates must not be negative")
+	}
+	a.rampLock.Lock()
+	defer a.rampLock.Unlock()
+	a.momentum = momentum
+	return nil
+}
+
+// AdjustSpeedTo takes the train to speed at the momentum's acceleration or deceleration.
+func (a *TrainSimulator) AdjustSpeedTo(speed int) error {
+	return a.AdjustSpeedToContext(context.Background(), speed)
+}
+
+// AdjustSpeedToContext is AdjustSpeedTo, giving up when ctx is done. Starting a new ramp
+// supersedes one in progress: it stops where it is, its caller gets ErrRampSuperseded, and the
+// new ramp carries on from there.
+func (a *TrainSimulator) AdjustSpeedToContext(ctx context.Context, speed int) error {
+	return a.ramp(ctx, speed, false)
+}
+
+// Brake stops the train at the momentum's brake rate, superseding any ramp in progress.
+func (a *TrainSimulator) Brake(ctx context.Context) error {
+	return a.ramp(ctx, 0, true)
+}
+
+// ramp takes the train to speed at the brake rate when braking, otherwise at the acceleration
+// or deceleration depending on the speed it is at once any ramp in progress has stopped
+func (a *TrainSimulator) ramp(ctx context.Context, speed int, brake bool) error {
+	maxSpeed := a.engine.maxSpeed()
+	if speed < 0 || maxSpeed < speed {
+		return fmt.Errorf("speed must be between 0 and %d", maxSpeed)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	a.rampLock.Lock()
+	previousCancel, previousDone := a.rampCancel, a.rampDone
+	a.rampCancel, a.rampDone = cancel, done
+	momentum := a.momentum
+	a.rampLock.Unlock()
+
+	defer func() {
+		cancel(nil)
+		a.rampLock.Lock()
+		if a.rampDone == done {
+			a.rampCancel, a.rampDone = nil, nil
+		}
+		a.rampLock.Unlock()
+		close(done)
+	}()
+
+	if previousCancel != nil {
+		previousCancel(ErrRampSuperseded)
+		// never let two ramps send commands at once
+		<-previousDone
+	}
+
+	initialSpeed := a.engine.GetSpeed()
+	rate := momentum.Acceleration
+	switch {
+	case brake:
+		rate = momentum.BrakeRate
+	case speed < initialSpeed:
+		rate = momentum.Deceleration
+	}
+	steps := speed - initialSpeed
+	increment := 1
+	if steps < 0 {
+		steps, increment = -steps, -1
+	}
+
+	emergencyStops := a.engine.EmergencyStops()
+	clock := momentum.Clock
+	if clock == nil {
+		clock = a.Clock()
+	}
+	duration := time.Duration(0)
+	if rate > 0 {
+		duration = time.Duration(float64(steps) / rate * float64(time.Second))
+	}
+	start := clock.Now()
+	for step := 1; step <= steps; step++ {
+		due := start.Add(time.Duration(momentum.Easing.at(float64(step)/float64(steps)) * float64(duration)))
+		if wait := due.Sub(clock.Now()); wait > 0 {
+			timer := clock.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return context.Cause(ctx)
+			case <-timer.C():
+			}
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if a.engine.EmergencyStops() != emergencyStops {
+			return ErrEmergencyStop
+		}
+
+		newSpeed := initialSpeed + increment*step
+		if momentum.EngineVolume != nil {
+			err := a.engine.SetEngineVolume(momentum.EngineVolume(newSpeed))
+			if err != nil {
+				return err
+			}
+		}
+		err := a.engine.SetSpeedUnlessStopped(newSpeed, emergencyStops)
+		if errors.Is(err, ErrPreempted) {
+			// the stop threw the step away before it was sent
+			return ErrEmergencyStop
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func TestEasing(t *testing.T) {
+	for _, test := range []struct {
+		easing Easing
+		// progress through the duration at which each quarter of the change is made
+		quarters [4]float64
+	}{
+		{EASING_LINEAR, [4]float64{0.25, 0.5, 0.75, 1}},
+		{EASING_EASE_IN_OUT, [4]float64{1.0 / 3, 0.5, 2.0 / 3, 1}},
+		{EASING_EXPONENTIAL, [4]float64{0.0414, 0.0997, 0.1996, 1}},
+	} {
+		t.Run(test.easing.String(), func(t *testing.T) {
+			if start, end := test.easing.apply(0), test.easing.apply(1); math.Abs(start) > 1e-9 || math.Abs(end-1) > 1e-9 {
+				t.Errorf("runs from '%v' to '%v', expected '0' to '1'", start, end)
+			}
+			for i, expected := range test.quarters {
+				change := float64(i+1) / 4
+				if at := test.easing.at(change); math.Abs(at-expected) > 1e-3 {
+					t.Errorf("change '%v' made at '%v', expected '%v'", change, at, expected)
+				}
+			}
+		})
+	}
+}
+
+// rampClock drives ramps with a VirtualClock
+type rampClock struct {
+	*VirtualClock
+	start time.Time
+}
+
+// step waits for the ramp to wait on the clock, checks it is due after from the start and
+// lets it go
+func (a rampClock) step(t *testing.T, after time.Duration) {
+	t.Helper()
+	a.BlockUntil(1)
+	a.lock.Lock()
+	due, now := a.timers[0].at, a.now
+	a.lock.Unlock()
+	if elapsed := due.Sub(a.start); elapsed.Round(time.Millisecond) != after {
+		t.Fatalf("step due '%v' in, expected '%v'", elapsed, after)
+	}
+	a.Advance(due.Sub(now))
+}
+
+// startRamp runs ramp in the background
+func startRamp(ramp func() error) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- ramp()
+	}()
+	return result
+}
+
+func waitRamp(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the ramp")
+		return nil
+	}
+}
+
+func TestRampTiming(t *testing.T) {
+	for _, test := range []struct {
+		easing Easing
+		due    []time.Duration
+	}{
+		{EASING_LINEAR, []time.Duration{500 * time.Millisecond, time.Second, 1500 * time.Millisecond, 2 * time.Second}},
+		{EASING_EASE_IN_OUT, []time.Duration{667 * time.Millisecond, time.Second, 1333 * time.Millisecond, 2 * time.Second}},
+	} {
+		t.Run(test.easing.String(), func(t *testing.T) {
+			simulator, transport := newTestSimulator(t)
+			clock := rampClock{NewVirtualClock(time.Unix(0, 0)), time.Unix(0, 0)}
+			err := simulator.SetMomentum(Momentum{Acceleration: 2, Easing: test.easing, Clock: clock})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := startRamp(func() error { return simulator.AdjustSpeedTo(4) })
+			for _, due := range test.due {
+				clock.step(t, due)
+			}
+			if err := waitRamp(t, result); err != nil {
+				t.Fatalf("AdjustSpeedTo failed: %v", err)
+			}
+			assertFrames(t, transport, protocol.SetSpeed{Speed: 1}, protocol.SetSpeed{Speed: 2}, protocol.SetSpeed{Speed: 3}, protocol.SetSpeed{Speed: 4})
+		})
+	}
+}
+
+func TestRampSupersede(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		target int
+		// every step of the new ramp, at the rate picked from where the old one stopped
+		due      time.Duration
+		expected []protocol.Command
+	}{
+		{"slowing down", 1, 250 * time.Millisecond, []protocol.Command{protocol.SetSpeed{Speed: 1}}},
+		{"speeding up", 4, time.Second, []protocol.Command{protocol.SetSpeed{Speed: 3}, protocol.SetSpeed{Speed: 4}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			simulator, transport := newTestSimulator(t)
+			clock := rampClock{NewVirtualClock(time.Unix(0, 0)), time.Unix(0, 0)}
+			err := simulator.SetMomentum(Momentum{Acceleration: 1, Deceleration: 4, Clock: clock})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first := startRamp(func() error { return simulator.AdjustSpeedTo(10) })
+			clock.step(t, time.Second)
+			clock.step(t, 2*time.Second)
+			clock.BlockUntil(1)
+
+			second := startRamp(func() error { return simulator.AdjustSpeedTo(test.target) })
+			if err := waitRamp(t, first); !errors.Is(err, ErrRampSuperseded) {
+				t.Fatalf("superseded ramp returned %v, expected %v", err, ErrRampSuperseded)
+			}
+			clock.start = clock.Now()
+			for step := 1; step <= len(test.expected); step++ {
+				clock.step(t, time.Duration(step)*test.due)
+			}
+			if err := waitRamp(t, second); err != nil {
+				t.Fatalf("new ramp failed: %v", err)
+			}
+			assertFrames(t, transport, append([]protocol.Command{protocol.SetSpeed{Speed: 1}, protocol.SetSpeed{Speed: 2}}, test.expected...)...)
+		})
+	}
+}
+
+func TestBrakeSupersedes(t *testing.T) {
+	simulator, transport := newTestSimulator(t)
+	clock := rampClock{NewVirtualClock(time.Unix(0, 0)), time.Unix(0, 0)}
+	err := simulator.SetMomentum(Momentum{Acceleration: 1, Deceleration: 1, BrakeRate: 10, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := startRamp(func() error { return simulator.AdjustSpeedTo(10) })
+	clock.step(t, time.Second)
+	clock.step(t, 2*time.Second)
+	clock.BlockUntil(1)
+
+	brake := startRamp(func() error { return simulator.Brake(context.Background()) })
+	if err := waitRamp(t, first); !errors.Is(err, ErrRampSuperseded) {
+		t.Fatalf("superseded ramp returned %v, expected %v", err, ErrRampSuperseded)
+	}
+	clock.start = clock.Now()
+	clock.step(t, 100*time.Millisecond)
+	clock.step(t, 200*time.Millisecond)
+	if err := waitRamp(t, brake); err != nil {
+		t.Fatalf("Brake failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.SetSpeed{Speed: 1}, protocol.SetSpeed{Speed: 2}, protocol.SetSpeed{Speed: 1}, protocol.SetSpeed{Speed: 0})
+}
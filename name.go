@@ -0,0 +1,119 @@
+package lionchief
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidDeviceName = errors.New("invalid device name")
+
+// DeviceIdentity is a LionChief advertised name split into its parts.
+// 'LC-0-1-0429-754D' is family 'LC', protocol 0, version 1, model code '0429' and unit '754D'.
+type DeviceIdentity struct {
+	Name      string
+	Family    string
+	Protocol  int
+	Version   int
+	ModelCode string
+	Unit      string
+}
+
+// Model is a catalogue entry for a locomotive, keyed by the model code in its advertised name.
+type Model struct {
+	Code          string
+	CatalogNumber string
+	Name          string
+}
+
+var (
+	modelsLock sync.RWMutex
+	models     = map[string]Model{
+		"0429": {Code: "0429", CatalogNumber: "6-83984", Name: "Pennsylvania Flyer"},
+	}
+)
+
+// RegisterModel adds (or replaces) a catalogue entry so names using its code resolve to it.
+func RegisterModel(model Model) {
+	modelsLock.Lock()
+	defer modelsLock.Unlock()
+	models[model.Code] = model
+}
+
+func LookupModel(code string) (Model, bool) {
+	modelsLock.RLock()
+	defer modelsLock.RUnlock()
+	model, ok := models[code]
+	return model, ok
+}
+
+func ParseDeviceName(name string) (DeviceIdentity, error) {
+	parts := strings.Split(name, "-")
+	if len(parts) != 5 {
+		return DeviceIdentity{}, fmt.Errorf("%w: '%s' must have '5' dash separated parts, has '%d'", ErrInvalidDeviceName, name, len(parts))
+	}
+
+	identity := DeviceIdentity{
+		Name:      name,
+		Family:    parts[0],
+		ModelCode: parts[3],
+		Unit:      parts[4],
+	}
+	if identity.Family+"-" != NamePrefix {
+		return DeviceIdentity{}, fmt.Errorf("%w: '%s' does not start with '%s'", ErrInvalidDeviceName, name, NamePrefix)
+	}
+
+	var err error
+	identity.Protocol, err = strconv.Atoi(parts[1])
+	if err != nil || identity.Protocol < 0 {
+		return DeviceIdentity{}, fmt.Errorf("%w: '%s' has a non numeric protocol '%s'", ErrInvalidDeviceName, name, parts[1])
+	}
+	identity.Version, err = strconv.Atoi(parts[2])
+	if err != nil || identity.Version < 0 {
+		return DeviceIdentity{}, fmt.Errorf("%w: '%s' has a non numeric version '%s'", ErrInvalidDeviceName, name, parts[2])
+	}
+
+	if len(identity.ModelCode) != 4 || !isDigits(identity.ModelCode) {
+		return DeviceIdentity{}, fmt.Errorf("%w: '%s' has an invalid model code '%s', expected 4 digits", ErrInvalidDeviceName, name, identity.ModelCode)
+	}
+	if len(identity.Unit) != 4 || !isHex(identity.Unit) {
+		return DeviceIdentity{}, fmt.Errorf("%w: '%s' has an invalid unit '%s', expected 4 hex digits", ErrInvalidDeviceName, name, identity.Unit)
+	}
+
+	return identity, nil
+}
+
+// Model looks the identity's model code up in the catalogue.
+func (a DeviceIdentity) Model() (Model, bool) {
+	return LookupModel(a.ModelCode)
+}
+
+// DisplayName is a human friendly name, e.g. 'Pennsylvania Flyer #754D'.
+func (a DeviceIdentity) DisplayName() string {
+	model, ok := a.Model()
+	if !ok {
+		return fmt.Sprintf("LionChief %s #%s", a.ModelCode, a.Unit)
+	}
+	return fmt.Sprintf("%s #%s", model.Name, a.Unit)
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(value string) bool {
+	_, err := strconv.ParseUint(value, 16, 64)
+	return err == nil
+}
+
+// Identity parses the device name the train reported about itself.
+func (a EngineInfo) Identity() (DeviceIdentity, error) {
+	return ParseDeviceName(a.DeviceName)
+}
@@ -0,0 +1,73 @@
+package lionchief
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDeviceName(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		expected DeviceIdentity
+	}{
+		{"LC-0-1-0429-754D", DeviceIdentity{Name: "LC-0-1-0429-754D", Family: "LC", Protocol: 0, Version: 1, ModelCode: "0429", Unit: "754D"}},
+		{"LC-2-13-1234-beef", DeviceIdentity{Name: "LC-2-13-1234-beef", Family: "LC", Protocol: 2, Version: 13, ModelCode: "1234", Unit: "beef"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := ParseDeviceName(test.name)
+			if err != nil {
+				t.Fatalf("ParseDeviceName failed: %v", err)
+			}
+			if identity != test.expected {
+				t.Errorf("ParseDeviceName returned '%+v', expected '%+v'", identity, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseDeviceNameInvalid(t *testing.T) {
+	for _, test := range []struct {
+		reason string
+		name   string
+	}{
+		{"empty", ""},
+		{"unknown prefix", "LX-0-1-0429-754D"},
+		{"lower case prefix", "lc-0-1-0429-754D"},
+		{"too few parts", "LC-0-1-0429"},
+		{"too many parts", "LC-0-1-0429-754D-1"},
+		{"non numeric protocol", "LC-x-1-0429-754D"},
+		{"empty protocol", "LC--1-0429-754D"},
+		{"non numeric version", "LC-0-v1-0429-754D"},
+		{"short model code", "LC-0-1-429-754D"},
+		{"long model code", "LC-0-1-04290-754D"},
+		{"non numeric model code", "LC-0-1-04A9-754D"},
+		{"short unit", "LC-0-1-0429-754"},
+		{"long unit", "LC-0-1-0429-754DA"},
+		{"non hex unit", "LC-0-1-0429-754G"},
+		{"empty unit", "LC-0-1-0429-"},
+	} {
+		t.Run(test.reason, func(t *testing.T) {
+			_, err := ParseDeviceName(test.name)
+			if !errors.Is(err, ErrInvalidDeviceName) {
+				t.Errorf("ParseDeviceName of '%s' returned %v, expected %v", test.name, err, ErrInvalidDeviceName)
+			}
+		})
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	known, err := ParseDeviceName("LC-0-1-0429-754D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := known.DisplayName(); name != "Pennsylvania Flyer #754D" {
+		t.Errorf("DisplayName of a catalogued model is '%s', expected 'Pennsylvania Flyer #754D'", name)
+	}
+	unknown, err := ParseDeviceName("LC-0-1-9999-0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := unknown.DisplayName(); name != "LionChief 9999 #0001" {
+		t.Errorf("DisplayName of an unknown model is '%s', expected 'LionChief 9999 #0001'", name)
+	}
+}
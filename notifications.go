@@ -0,0 +1,52 @@
+package lionchief
+
+import (
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// Notification is a single frame received on the read characteristic.
+// Frames the protocol package understands carry the decoded Command, anything else
+// is still delivered with Command left nil and Err explaining why it was not decoded.
+type Notification struct {
+	Received time.Time
+	Raw      []byte
+	Command  protocol.Command
+	Err      error
+}
+
+func (a *TrainEngine) handleNotification(frame []byte) {
+	notification := Notification{
+		Received: time.Now(),
+		Raw:      frame,
+	}
+	notification.Command, notification.Err = protocol.Unmarshal(frame)
+
+	if notification.Err != nil {
+		a.logger.Printf("Received unknown notification '% x': %v", frame, notification.Err)
+	} else {
+		a.reportedLock.Lock()
+		applyCommand(&a.reported, notification.Command)
+		a.reportedLock.Unlock()
+	}
+
+	select {
+	case a.notifications <- notification:
+	default:
+		a.logger.Printf("Dropping notification '% x', nobody is reading them", frame)
+	}
+}
+
+// Notifications returns the stream of frames received from the train.
+func (a *TrainEngine) Notifications() <-chan Notification {
+	return a.notifications
+}
+
+// GetReportedState returns the state as echoed back by the train itself, as opposed to the
+// state the engine has asked for.
+func (a *TrainEngine) GetReportedState() TrainState {
+	a.reportedLock.Lock()
+	defer a.reportedLock.Unlock()
+	return a.reported.clone()
+}
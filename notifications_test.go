@@ -0,0 +1,56 @@
+package lionchief
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func TestNotifications(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		frame   []byte
+		command protocol.Command
+		err     error
+	}{
+		{"speed", []byte{0x00, 0x45, 0x05, 0x4a}, protocol.SetSpeed{Speed: 5}, nil},
+		{"reverse", []byte{0x00, 0x46, 0x02, 0x48}, protocol.SetDirection{Direction: protocol.DirectionReverse}, nil},
+		{"lights off", []byte{0x00, 0x51, 0x00, 0x51}, protocol.Lights{On: false}, nil},
+		{"bad checksum", []byte{0x00, 0x45, 0x05, 0x4b}, nil, protocol.ErrChecksum},
+		{"unknown opcode", []byte{0x00, 0x40, 0x00, 0x40}, nil, protocol.ErrUnknownOpcode},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine, transport := newTestEngine(t)
+			transport.Notify(test.frame)
+
+			notification := <-engine.Notifications()
+			if string(notification.Raw) != string(test.frame) {
+				t.Errorf("notification raw frame '% x', expected '% x'", notification.Raw, test.frame)
+			}
+			if notification.Command != test.command {
+				t.Errorf("notification command %#v, expected %#v", notification.Command, test.command)
+			}
+			if !errors.Is(notification.Err, test.err) {
+				t.Errorf("notification error %v, expected %v", notification.Err, test.err)
+			}
+		})
+	}
+}
+
+func TestReportedState(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	transport.Notify([]byte{0x00, 0x45, 0x05, 0x4a})
+	transport.Notify([]byte{0x00, 0x46, 0x02, 0x48})
+	// garbage is reported but never changes the state
+	transport.Notify([]byte{0x00, 0x45, 0x07, 0x00})
+
+	reported := engine.GetReportedState()
+	if reported.Speed != 5 || !reported.Reverse {
+		t.Errorf("reported state has speed '%d' and reverse '%v', expected '5' and 'true'", reported.Speed, reported.Reverse)
+	}
+	// what the train says it is doing is kept apart from what was asked of it
+	if engine.GetSpeed() != 0 {
+		t.Errorf("desired speed is '%d' after a notification, expected '0'", engine.GetSpeed())
+	}
+}
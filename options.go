@@ -0,0 +1,108 @@
+package lionchief
+
+import (
+	"log"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Option customises how an engine is constructed.
+type Option func(*engineConfig)
+
+type engineConfig struct {
+	adapter          *bluetooth.Adapter
+	connectionParams bluetooth.ConnectionParams
+	logger           *log.Logger
+	resetOnConnect   bool
+	reconnectPolicy  ReconnectPolicy
+	restorePolicy    RestorePolicy
+	rateLimit        RateLimit
+	watchdog         *WatchdogConfig
+	interlocks       Interlocks
+	clock            Clock
+}
+
+func newEngineConfig(opts []Option) engineConfig {
+	config := engineConfig{
+		adapter:         bluetooth.DefaultAdapter,
+		logger:          log.Default(),
+		resetOnConnect:  true,
+		reconnectPolicy: DefaultReconnectPolicy,
+		restorePolicy:   RESTOREPOLICY_ALL_BUT_SPEED,
+		rateLimit:       DefaultRateLimit,
+		interlocks:      DefaultInterlocks,
+		clock:           SystemClock,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// WithAdapter connects through adapter instead of bluetooth.DefaultAdapter.
+func WithAdapter(adapter *bluetooth.Adapter) Option {
+	return func(config *engineConfig) {
+		config.adapter = adapter
+	}
+}
+
+func WithConnectionParams(params bluetooth.ConnectionParams) Option {
+	return func(config *engineConfig) {
+		config.connectionParams = params
+	}
+}
+
+// WithLogger sends the engine's logging to logger instead of the standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(config *engineConfig) {
+		config.logger = logger
+	}
+}
+
+// WithResetOnConnect controls whether the train is reset to the default state on connect, it is by default.
+func WithResetOnConnect(reset bool) Option {
+	return func(config *engineConfig) {
+		config.resetOnConnect = reset
+	}
+}
+
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(config *engineConfig) {
+		config.reconnectPolicy = policy
+	}
+}
+
+func WithRestorePolicy(policy RestorePolicy) Option {
+	return func(config *engineConfig) {
+		config.restorePolicy = policy
+	}
+}
+
+// WithRateLimit caps how fast frames are written, see RateLimit.
+func WithRateLimit(limit RateLimit) Option {
+	return func(config *engineConfig) {
+		config.rateLimit = limit
+	}
+}
+
+// WithWatchdog enables the watchdog as soon as the engine is connected, see WatchdogConfig.
+func WithWatchdog(watchdog WatchdogConfig) Option {
+	return func(config *engineConfig) {
+		config.watchdog = &watchdog
+	}
+}
+
+// WithInterlocks replaces DefaultInterlocks, see Interlocks.
+func WithInterlocks(interlocks Interlocks) Option {
+	return func(config *engineConfig) {
+		config.interlocks = interlocks
+	}
+}
+
+// WithClock times the watchdog, and simulators wrapping the engine, by clock instead of the
+// SystemClock, e.g. a VirtualClock.
+func WithClock(clock Clock) Option {
+	return func(config *engineConfig) {
+		config.clock = clock
+	}
+}
@@ -0,0 +1,311 @@
+package lionchief
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+type Capability string
+
+const (
+	CAPABILITY_SPEED         Capability = "speed"
+	CAPABILITY_DIRECTION     Capability = "direction"
+	CAPABILITY_BELL          Capability = "bell"
+	CAPABILITY_HORN          Capability = "horn"
+	CAPABILITY_LIGHTS        Capability = "lights"
+	CAPABILITY_SPEAK         Capability = "speak"
+	CAPABILITY_SOUND_VOLUME  Capability = "sound_volume"
+	CAPABILITY_SOUND_PITCH   Capability = "sound_pitch"
+	CAPABILITY_MASTER_VOLUME Capability = "master_volume"
+	CAPABILITY_DISCONNECT    Capability = "disconnect"
+)
+
+const (
+	LOCOMOTIVE_STEAM  = "steam"
+	LOCOMOTIVE_DIESEL = "diesel"
+)
+
+// GenericProfileName is the profile used when nothing more specific matches the train
+const GenericProfileName = "Generic LionChief"
+
+var ErrUnsupportedCommand = errors.New("command not supported by engine profile")
+
+type Range struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+func (a Range) Contains(value int) bool {
+	return a.Min <= value && value <= a.Max
+}
+
+type Phrase struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	Text              string `json:"text"`
+	DurationMs        int    `json:"duration_ms"`
+	ExcludeFromRandom bool   `json:"exclude_from_random,omitempty"`
+}
+
+// Duration is roughly how long the phrase takes to say.
+func (a Phrase) Duration() time.Duration {
+	return time.Duration(a.DurationMs) * time.Millisecond
+}
+
+// EngineProfile describes what a particular model of locomotive can do.
+// An empty Commands list means every command is supported, an empty Phrases list means
+// the phrases are unknown and any phrase id is sent as is.
+type EngineProfile struct {
+	Name          string           `json:"name"`
+	CatalogNumber string           `json:"catalog_number,omitempty"`
+	ModelCodes    []string         `json:"model_codes,omitempty"`
+	ModelNumbers  []string         `json:"model_numbers,omitempty"`
+	Locomotive    string           `json:"locomotive"`
+	Commands      []Capability     `json:"commands,omitempty"`
+	Speed         Range            `json:"speed"`
+	MasterVolume  Range            `json:"master_volume"`
+	Volumes       map[string]Range `json:"volumes"`
+	Phrases       []Phrase         `json:"phrases,omitempty"`
+}
+
+//go:embed profiles/*.json
+var embeddedProfiles embed.FS
+
+var (
+	profilesLock sync.RWMutex
+	profiles     = map[string]*EngineProfile{}
+)
+
+func init() {
+	entries, err := embeddedProfiles.ReadDir("profiles")
+	must("read embedded profiles", err)
+	for _, entry := range entries {
+		data, err := embeddedProfiles.ReadFile("profiles/" + entry.Name())
+		must("read embedded profile '"+entry.Name()+"'", err)
+		profile, err := ParseProfile(data)
+		must("parse embedded profile '"+entry.Name()+"'", err)
+		RegisterProfile(profile)
+	}
+}
+
+func soundTypeName(soundType SoundType) string {
+	switch soundType {
+	case SOUNDTYPE_HORN:
+		return "horn"
+	case SOUNDTYPE_BELL:
+		return "bell"
+	case SOUNDTYPE_SPEECH:
+		return "speech"
+	case SOUNDTYPE_ENGINE:
+		return "engine"
+	}
+	return fmt.Sprintf("sound_%d", soundType)
+}
+
+func ParseProfile(data []byte) (*EngineProfile, error) {
+	profile := EngineProfile{}
+	err := json.Unmarshal(data, &profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.Name == "" {
+		return nil, errors.New("profile must have a name")
+	}
+	if profile.Locomotive != LOCOMOTIVE_STEAM && profile.Locomotive != LOCOMOTIVE_DIESEL {
+		return nil, fmt.Errorf("profile '%s' locomotive must be '%s' or '%s'", profile.Name, LOCOMOTIVE_STEAM, LOCOMOTIVE_DIESEL)
+	}
+
+	// a missing range would read as 0 to 0 and refuse every command using it
+	required := struct {
+		Speed        *Range `json:"speed"`
+		MasterVolume *Range `json:"master_volume"`
+	}{}
+	err = json.Unmarshal(data, &required)
+	if err != nil {
+		return nil, err
+	}
+	if required.Speed == nil {
+		return nil, fmt.Errorf("profile '%s' is missing a 'speed' range", profile.Name)
+	}
+	if required.MasterVolume == nil {
+		return nil, fmt.Errorf("profile '%s' is missing a 'master_volume' range", profile.Name)
+	}
+	err = profile.Speed.validate(profile.Name, "speed", protocol.MaxSpeed)
+	if err != nil {
+		return nil, err
+	}
+	// every stop, and resetting the train, goes through speed 0
+	if profile.Speed.Min != 0 {
+		return nil, fmt.Errorf("profile '%s' has invalid 'speed' range '%d' to '%d', must start at '0'", profile.Name, profile.Speed.Min, profile.Speed.Max)
+	}
+	err = profile.MasterVolume.validate(profile.Name, "master_volume", protocol.MaxMasterVolume)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, soundType := range []SoundType{SOUNDTYPE_HORN, SOUNDTYPE_BELL, SOUNDTYPE_SPEECH, SOUNDTYPE_ENGINE} {
+		volume, ok := profile.Volumes[soundTypeName(soundType)]
+		if !ok {
+			return nil, fmt.Errorf("profile '%s' is missing a '%s' volume range", profile.Name, soundTypeName(soundType))
+		}
+		err = volume.validate(profile.Name, soundTypeName(soundType)+" volume", protocol.MaxSoundLevel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &profile, nil
+}
+
+// validate checks the range lies within what the protocol can send
+func (a Range) validate(profile string, name string, limit int) error {
+	if a.Min < 0 || a.Max > limit || a.Min > a.Max {
+		return fmt.Errorf("profile '%s' has invalid '%s' range '%d' to '%d', must be within '0' to '%d'", profile, name, a.Min, a.Max, limit)
+	}
+	return nil
+}
+
+// LoadProfile reads a profile from a JSON file and registers it, replacing any profile of the same name.
+func LoadProfile(path string) (*EngineProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := ParseProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile '%s': %w", path, err)
+	}
+	RegisterProfile(profile)
+	return profile, nil
+}
+
+// LoadUserProfiles loads every profile in the 'lionchief/profiles' folder of the user config dir.
+// A missing folder is not an error.
+func LoadUserProfiles() error {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(configDir, "lionchief", "profiles", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		_, err = LoadProfile(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func RegisterProfile(profile *EngineProfile) {
+	profilesLock.Lock()
+	defer profilesLock.Unlock()
+	profiles[profile.Name] = profile
+}
+
+func LookupProfile(name string) (*EngineProfile, bool) {
+	profilesLock.RLock()
+	defer profilesLock.RUnlock()
+	profile, ok := profiles[name]
+	return profile, ok
+}
+
+// SelectProfile picks the profile for a train, by DIS model number first and then by the
+// model code in its device name, falling back to the generic profile. Where several profiles
+// match the first by name wins.
+func SelectProfile(modelNumber string, deviceName string) *EngineProfile {
+	profilesLock.RLock()
+	defer profilesLock.RUnlock()
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if modelNumber != "" {
+		for _, name := range names {
+			profile := profiles[name]
+			if slices.Contains(profile.ModelNumbers, modelNumber) {
+				return profile
+			}
+		}
+	}
+
+	identity, err := ParseDeviceName(deviceName)
+	if err == nil {
+		for _, name := range names {
+			profile := profiles[name]
+			if slices.Contains(profile.ModelCodes, identity.ModelCode) {
+				return profile
+			}
+		}
+	}
+
+	return profiles[GenericProfileName]
+}
+
+func (a *EngineProfile) Supports(capability Capability) bool {
+	return len(a.Commands) == 0 || slices.Contains(a.Commands, capability)
+}
+
+func (a *EngineProfile) VolumeRange(soundType SoundType) Range {
+	return a.Volumes[soundTypeName(soundType)]
+}
+
+func (a *EngineProfile) Phrase(id int) (Phrase, bool) {
+	for _, phrase := range a.Phrases {
+		if phrase.ID == id {
+			return phrase, true
+		}
+	}
+	return Phrase{}, false
+}
+
+func (a *EngineProfile) PhraseByName(name string) (Phrase, bool) {
+	for _, phrase := range a.Phrases {
+		if strings.EqualFold(phrase.Name, name) {
+			return phrase, true
+		}
+	}
+	return Phrase{}, false
+}
+
+// RandomPhrases are the phrases suitable for picking at random.
+func (a *EngineProfile) RandomPhrases() []Phrase {
+	phrases := []Phrase{}
+	for _, phrase := range a.Phrases {
+		if !phrase.ExcludeFromRandom {
+			phrases = append(phrases, phrase)
+		}
+	}
+	return phrases
+}
+
+// HornName is what the horn is called on this locomotive, steam engines have a whistle.
+func (a *EngineProfile) HornName() string {
+	if a.Locomotive == LOCOMOTIVE_STEAM {
+		return "whistle"
+	}
+	return "horn"
+}
+
+func (a *EngineProfile) check(capability Capability) error {
+	if !a.Supports(capability) {
+		return fmt.Errorf("%w: '%s' on '%s'", ErrUnsupportedCommand, capability, a.Name)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package lionchief
+
+import (
+	"strings"
+	"testing"
+)
+
+const testProfileVolumes = `"volumes": {
+	"horn": { "min": 0, "max": 13 },
+	"bell": { "min": 0, "max": 13 },
+	"speech": { "min": 0, "max": 13 },
+	"engine": { "min": 0, "max": 13 }
+}`
+
+func TestParseProfileRanges(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		json string
+		err  string
+	}{
+		{"valid", `"speed": { "min": 0, "max": 31 }, "master_volume": { "min": 0, "max": 7 },` + testProfileVolumes, ""},
+		{"missing speed", `"master_volume": { "min": 0, "max": 7 },` + testProfileVolumes, "missing a 'speed' range"},
+		{"null speed", `"speed": null, "master_volume": { "min": 0, "max": 7 },` + testProfileVolumes, "missing a 'speed' range"},
+		{"missing master volume", `"speed": { "min": 0, "max": 31 },` + testProfileVolumes, "missing a 'master_volume' range"},
+		{"speed too high", `"speed": { "min": 0, "max": 32 }, "master_volume": { "min": 0, "max": 7 },` + testProfileVolumes, "invalid 'speed' range"},
+		{"speed not from zero", `"speed": { "min": 1, "max": 31 }, "master_volume": { "min": 0, "max": 7 },` + testProfileVolumes, "must start at '0'"},
+		{"speed inverted", `"speed": { "min": 10, "max": 5 }, "master_volume": { "min": 0, "max": 7 },` + testProfileVolumes, "invalid 'speed' range"},
+		{"master volume too high", `"speed": { "min": 0, "max": 31 }, "master_volume": { "min": 0, "max": 8 },` + testProfileVolumes, "invalid 'master_volume' range"},
+		{"horn volume too high", `"speed": { "min": 0, "max": 31 }, "master_volume": { "min": 0, "max": 7 },` + strings.Replace(testProfileVolumes, `"max": 13`, `"max": 14`, 1), "invalid 'horn volume' range"},
+		{"negative volume", `"speed": { "min": 0, "max": 31 }, "master_volume": { "min": -1, "max": 7 },` + testProfileVolumes, "invalid 'master_volume' range"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseProfile([]byte(`{"name": "Test", "locomotive": "diesel", ` + test.json + `}`))
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("ParseProfile failed: %v", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("ParseProfile error = %v, expected one containing '%s'", err, test.err)
+			}
+		})
+	}
+}
+
+func TestEmbeddedProfilesParse(t *testing.T) {
+	entries, err := embeddedProfiles.ReadDir("profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := embeddedProfiles.ReadFile("profiles/" + entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ParseProfile(data)
+		if err != nil {
+			t.Errorf("embedded profile '%s': %v", entry.Name(), err)
+		}
+	}
+}
+
+func TestSelectProfileOrder(t *testing.T) {
+	for _, name := range []string{"Test B", "Test A", "Test C"} {
+		RegisterProfile(&EngineProfile{Name: name, ModelNumbers: []string{"test-model"}, ModelCodes: []string{"9999"}})
+	}
+	t.Cleanup(func() {
+		profilesLock.Lock()
+		defer profilesLock.Unlock()
+		for _, name := range []string{"Test B", "Test A", "Test C"} {
+			delete(profiles, name)
+		}
+	})
+
+	// map order changes from run to run, the pick must not
+	for i := 0; i < 20; i++ {
+		if profile := SelectProfile("test-model", ""); profile.Name != "Test A" {
+			t.Fatalf("SelectProfile by model number picked '%s', expected 'Test A'", profile.Name)
+		}
+	}
+	if profile := SelectProfile("unknown", ""); profile.Name != GenericProfileName {
+		t.Errorf("SelectProfile of an unknown model picked '%s', expected '%s'", profile.Name, GenericProfileName)
+	}
+}
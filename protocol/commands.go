@@ -0,0 +1,259 @@
+package protocol
+
+import "fmt"
+
+type Opcode byte
+
+const (
+	OpSound        Opcode = 0x44
+	OpSpeed        Opcode = 0x45
+	OpDirection    Opcode = 0x46
+	OpBell         Opcode = 0x47
+	OpHorn         Opcode = 0x48
+	OpDisconnect   Opcode = 0x4b
+	OpMasterVolume Opcode = 0x4c
+	OpSpeak        Opcode = 0x4d
+	OpLights       Opcode = 0x51
+)
+
+type SoundType byte
+
+const (
+	SoundHorn   SoundType = 1
+	SoundBell   SoundType = 2
+	SoundSpeech SoundType = 3
+	SoundEngine SoundType = 4
+)
+
+// Pitch is signed on the wire, negative values are sent in 2's compliment.
+type Pitch int8
+
+const (
+	PitchLowest  Pitch = -2
+	PitchLow     Pitch = -1
+	PitchNormal  Pitch = 0
+	PitchHigh    Pitch = 1
+	PitchHighest Pitch = 2
+)
+
+type Direction byte
+
+const (
+	DirectionForward Direction = 1
+	DirectionReverse Direction = 2
+)
+
+const (
+	MaxSpeed        = 0x1f
+	MaxSoundLevel   = 13
+	MaxMasterVolume = 7
+
+	// keepLevel is sent in place of a volume when only the pitch should change
+	keepLevel = 0x0e
+)
+
+// Command is a single typed train command.
+type Command interface {
+	Opcode() Opcode
+	// Payload validates the command and returns its unframed bytes, opcode first.
+	Payload() ([]byte, error)
+}
+
+type SetSpeed struct {
+	Speed uint8
+}
+
+type SetDirection struct {
+	Direction Direction
+}
+
+type SetSoundVolume struct {
+	Type  SoundType
+	Level uint8
+}
+
+type SetSoundPitch struct {
+	Type  SoundType
+	Pitch Pitch
+}
+
+type Speak struct {
+	Phrase uint8
+}
+
+type Lights struct {
+	On bool
+}
+
+type Bell struct {
+	On bool
+}
+
+type Horn struct {
+	On bool
+}
+
+type Disconnect struct{}
+
+type MasterVolume struct {
+	Level uint8
+}
+
+func (SetSpeed) Opcode() Opcode       { return OpSpeed }
+func (SetDirection) Opcode() Opcode   { return OpDirection }
+func (SetSoundVolume) Opcode() Opcode { return OpSound }
+func (SetSoundPitch) Opcode() Opcode  { return OpSound }
+func (Speak) Opcode() Opcode          { return OpSpeak }
+func (Lights) Opcode() Opcode         { return OpLights }
+func (Bell) Opcode() Opcode           { return OpBell }
+func (Horn) Opcode() Opcode           { return OpHorn }
+func (Disconnect) Opcode() Opcode     { return OpDisconnect }
+func (MasterVolume) Opcode() Opcode   { return OpMasterVolume }
+
+func (c SetSpeed) Payload() ([]byte, error) {
+	if c.Speed > MaxSpeed {
+		return nil, fmt.Errorf("%w: speed must be between '0' and '%d' (inclusive)", ErrOutOfRange, MaxSpeed)
+	}
+	return []byte{byte(OpSpeed), c.Speed}, nil
+}
+
+func (c SetDirection) Payload() ([]byte, error) {
+	if c.Direction != DirectionForward && c.Direction != DirectionReverse {
+		return nil, fmt.Errorf("%w: unknown direction '%d'", ErrOutOfRange, c.Direction)
+	}
+	return []byte{byte(OpDirection), byte(c.Direction)}, nil
+}
+
+func (c SetSoundVolume) Payload() ([]byte, error) {
+	if err := validSoundType(c.Type); err != nil {
+		return nil, err
+	}
+	if c.Level > MaxSoundLevel {
+		return nil, fmt.Errorf("%w: volume must be between '0' and '%d' (inclusive)", ErrOutOfRange, MaxSoundLevel)
+	}
+	return []byte{byte(OpSound), byte(c.Type), c.Level}, nil
+}
+
+func (c SetSoundPitch) Payload() ([]byte, error) {
+	if err := validSoundType(c.Type); err != nil {
+		return nil, err
+	}
+	if c.Pitch < PitchLowest || c.Pitch > PitchHighest {
+		return nil, fmt.Errorf("%w: pitch must be between '%d' and '%d' (inclusive)", ErrOutOfRange, PitchLowest, PitchHighest)
+	}
+	return []byte{byte(OpSound), byte(c.Type), keepLevel, byte(c.Pitch)}, nil
+}
+
+func (c Speak) Payload() ([]byte, error) {
+	return []byte{byte(OpSpeak), c.Phrase, 0}, nil
+}
+
+func (c Lights) Payload() ([]byte, error) {
+	return []byte{byte(OpLights), boolByte(c.On)}, nil
+}
+
+func (c Bell) Payload() ([]byte, error) {
+	return []byte{byte(OpBell), boolByte(c.On)}, nil
+}
+
+func (c Horn) Payload() ([]byte, error) {
+	return []byte{byte(OpHorn), boolByte(c.On)}, nil
+}
+
+func (c Disconnect) Payload() ([]byte, error) {
+	return []byte{byte(OpDisconnect), 0, 0}, nil
+}
+
+func (c MasterVolume) Payload() ([]byte, error) {
+	if c.Level > MaxMasterVolume {
+		return nil, fmt.Errorf("%w: volume must be between '0' and '%d' (inclusive)", ErrOutOfRange, MaxMasterVolume)
+	}
+	return []byte{byte(OpMasterVolume), c.Level}, nil
+}
+
+// ParsePayload parses an unframed payload (opcode first) into its typed command.
+// Anything that would not survive a round trip through Payload is rejected.
+func ParsePayload(payload []byte) (Command, error) {
+	if len(payload) < 1 {
+		return nil, fmt.Errorf("%w: empty payload", ErrShortFrame)
+	}
+
+	var cmd Command
+	op := Opcode(payload[0])
+	args := payload[1:]
+	switch op {
+	case OpSound:
+		switch {
+		case len(args) == 2:
+			cmd = SetSoundVolume{Type: SoundType(args[0]), Level: args[1]}
+		case len(args) == 3 && args[1] == keepLevel:
+			cmd = SetSoundPitch{Type: SoundType(args[0]), Pitch: Pitch(int8(args[2]))}
+		default:
+			return nil, malformed(op, args)
+		}
+	case OpSpeed:
+		if len(args) != 1 {
+			return nil, malformed(op, args)
+		}
+		cmd = SetSpeed{Speed: args[0]}
+	case OpDirection:
+		if len(args) != 1 {
+			return nil, malformed(op, args)
+		}
+		cmd = SetDirection{Direction: Direction(args[0])}
+	case OpBell, OpHorn, OpLights:
+		if len(args) != 1 || args[0] > 1 {
+			return nil, malformed(op, args)
+		}
+		on := args[0] == 1
+		switch op {
+		case OpBell:
+			cmd = Bell{On: on}
+		case OpHorn:
+			cmd = Horn{On: on}
+		default:
+			cmd = Lights{On: on}
+		}
+	case OpDisconnect:
+		if len(args) != 2 || args[0] != 0 || args[1] != 0 {
+			return nil, malformed(op, args)
+		}
+		cmd = Disconnect{}
+	case OpMasterVolume:
+		if len(args) != 1 {
+			return nil, malformed(op, args)
+		}
+		cmd = MasterVolume{Level: args[0]}
+	case OpSpeak:
+		if len(args) != 2 || args[1] != 0 {
+			return nil, malformed(op, args)
+		}
+		cmd = Speak{Phrase: args[0]}
+	default:
+		return nil, fmt.Errorf("%w: '%#02x'", ErrUnknownOpcode, byte(op))
+	}
+
+	// run it back through the encoder so range checks live in one place
+	if _, err := cmd.Payload(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func validSoundType(soundType SoundType) error {
+	if soundType < SoundHorn || soundType > SoundEngine {
+		return fmt.Errorf("%w: unknown sound type '%d'", ErrOutOfRange, soundType)
+	}
+	return nil
+}
+
+func boolByte(value bool) byte {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func malformed(op Opcode, args []byte) error {
+	return fmt.Errorf("%w: opcode '%#02x' with arguments '% x'", ErrMalformed, byte(op), args)
+}
@@ -0,0 +1,26 @@
+// Package protocol encodes and decodes the LionChief BLE command frames.
+//
+// Every frame on the wire is a leading 0x00, the command payload and a one byte
+// checksum (the sum of the payload bytes, truncated to a byte).
+//
+// Train Commands in HEX (payload only)
+// Set horn volume/pitch: 44 01 <00-0f> <fe-02>
+// Set bell volume/pitch: 44 02 <00-0f> <fe-02>
+// Set speech volume/pitch: 44 03 <00-0f> <fe-02>
+// Set engine volume/pitch: 44 04 <00-0f> <fe-02>
+// Set speed : 45 <00-1f>
+// Forward : 46 01
+// Reverse : 46 02
+// Bell start: 47 01
+// Bell stop : 47 00
+// Horn start: 48 01
+// Horn stop : 48 00
+// Disconnect: 4b 0 0
+// Set overall volume: 4c <00-07>
+// Speech : 4d XX 00
+// Set lights off: 51 00
+// Set lights on: 51 01
+//
+// A pitch change on its own is sent with the volume byte set to 0x0e, which the
+// train reads as "leave the volume alone".
+package protocol
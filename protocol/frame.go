@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrShortFrame    = errors.New("frame too short")
+	ErrBadLeader     = errors.New("frame does not start with 0x00")
+	ErrChecksum      = errors.New("frame checksum mismatch")
+	ErrUnknownOpcode = errors.New("unknown opcode")
+	ErrMalformed     = errors.New("malformed command")
+	ErrOutOfRange    = errors.New("value out of range")
+)
+
+// Checksum is the sum of the payload bytes, truncated to a byte.
+func Checksum(payload []byte) byte {
+	var sum byte
+	for _, value := range payload {
+		sum += value
+	}
+	return sum
+}
+
+// Frame wraps a raw payload with the leading 0x00 and the trailing checksum.
+func Frame(payload []byte) []byte {
+	frame := make([]byte, len(payload)+2)
+	frame[0] = 0
+	copy(frame[1:], payload)
+	frame[len(payload)+1] = Checksum(payload)
+	return frame
+}
+
+// Unframe validates the leader and checksum of a frame and returns its payload.
+func Unframe(frame []byte) ([]byte, error) {
+	// leader, opcode and checksum at the very least
+	if len(frame) < 3 {
+		return nil, fmt.Errorf("%w: got '%d' bytes", ErrShortFrame, len(frame))
+	}
+	if frame[0] != 0 {
+		return nil, fmt.Errorf("%w: got '%#02x'", ErrBadLeader, frame[0])
+	}
+
+	payload := frame[1 : len(frame)-1]
+	expected := Checksum(payload)
+	if frame[len(frame)-1] != expected {
+		return nil, fmt.Errorf("%w: got '%#02x', expected '%#02x'", ErrChecksum, frame[len(frame)-1], expected)
+	}
+
+	out := make([]byte, len(payload))
+	copy(out, payload)
+	return out, nil
+}
+
+// Marshal encodes a command into its framed wire form.
+func Marshal(cmd Command) ([]byte, error) {
+	payload, err := cmd.Payload()
+	if err != nil {
+		return nil, err
+	}
+	return Frame(payload), nil
+}
+
+// Unmarshal parses a framed command back into its typed form.
+func Unmarshal(frame []byte) (Command, error) {
+	payload, err := Unframe(frame)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePayload(payload)
+}
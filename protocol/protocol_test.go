@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// golden frames, leader, payload and checksum, as the train expects them on the wire
+var goldenFrames = []struct {
+	name  string
+	cmd   Command
+	frame []byte
+}{
+	{"speed 0", SetSpeed{Speed: 0}, []byte{0x00, 0x45, 0x00, 0x45}},
+	{"speed 5", SetSpeed{Speed: 5}, []byte{0x00, 0x45, 0x05, 0x4a}},
+	{"speed max", SetSpeed{Speed: MaxSpeed}, []byte{0x00, 0x45, 0x1f, 0x64}},
+	{"forward", SetDirection{Direction: DirectionForward}, []byte{0x00, 0x46, 0x01, 0x47}},
+	{"reverse", SetDirection{Direction: DirectionReverse}, []byte{0x00, 0x46, 0x02, 0x48}},
+	{"horn volume", SetSoundVolume{Type: SoundHorn, Level: 7}, []byte{0x00, 0x44, 0x01, 0x07, 0x4c}},
+	{"engine volume", SetSoundVolume{Type: SoundEngine, Level: MaxSoundLevel}, []byte{0x00, 0x44, 0x04, 0x0d, 0x55}},
+	{"bell pitch low", SetSoundPitch{Type: SoundBell, Pitch: PitchLow}, []byte{0x00, 0x44, 0x02, 0x0e, 0xff, 0x53}},
+	{"speech pitch highest", SetSoundPitch{Type: SoundSpeech, Pitch: PitchHighest}, []byte{0x00, 0x44, 0x03, 0x0e, 0x02, 0x57}},
+	{"speak", Speak{Phrase: 4}, []byte{0x00, 0x4d, 0x04, 0x00, 0x51}},
+	{"lights on", Lights{On: true}, []byte{0x00, 0x51, 0x01, 0x52}},
+	{"lights off", Lights{On: false}, []byte{0x00, 0x51, 0x00, 0x51}},
+	{"bell on", Bell{On: true}, []byte{0x00, 0x47, 0x01, 0x48}},
+	{"horn off", Horn{On: false}, []byte{0x00, 0x48, 0x00, 0x48}},
+	{"disconnect", Disconnect{}, []byte{0x00, 0x4b, 0x00, 0x00, 0x4b}},
+	{"master volume", MasterVolume{Level: 5}, []byte{0x00, 0x4c, 0x05, 0x51}},
+}
+
+func TestMarshalGolden(t *testing.T) {
+	for _, golden := range goldenFrames {
+		t.Run(golden.name, func(t *testing.T) {
+			frame, err := Marshal(golden.cmd)
+			if err != nil {
+				t.Fatalf("Marshal(%#v) failed: %v", golden.cmd, err)
+			}
+			if !bytes.Equal(frame, golden.frame) {
+				t.Fatalf("Marshal(%#v) = '% x', expected '% x'", golden.cmd, frame, golden.frame)
+			}
+		})
+	}
+}
+
+func TestUnmarshalGolden(t *testing.T) {
+	for _, golden := range goldenFrames {
+		t.Run(golden.name, func(t *testing.T) {
+			cmd, err := Unmarshal(golden.frame)
+			if err != nil {
+				t.Fatalf("Unmarshal('% x') failed: %v", golden.frame, err)
+			}
+			if cmd != golden.cmd {
+				t.Fatalf("Unmarshal('% x') = %#v, expected %#v", golden.frame, cmd, golden.cmd)
+			}
+		})
+	}
+}
+
+func TestMarshalRejectsOutOfRange(t *testing.T) {
+	for _, cmd := range []Command{
+		SetSpeed{Speed: MaxSpeed + 1},
+		SetDirection{Direction: 0},
+		SetDirection{Direction: 3},
+		SetSoundVolume{Type: SoundHorn, Level: MaxSoundLevel + 1},
+		SetSoundVolume{Type: 0, Level: 1},
+		SetSoundPitch{Type: SoundEngine, Pitch: PitchHighest + 1},
+		SetSoundPitch{Type: SoundEngine + 1, Pitch: PitchNormal},
+		MasterVolume{Level: MaxMasterVolume + 1},
+	} {
+		_, err := Marshal(cmd)
+		if !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("Marshal(%#v) error = %v, expected %v", cmd, err, ErrOutOfRange)
+		}
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"empty", []byte{}, ErrShortFrame},
+		{"no payload", []byte{0x00, 0x00}, ErrShortFrame},
+		{"bad leader", []byte{0x01, 0x45, 0x05, 0x4a}, ErrBadLeader},
+		{"bad checksum", []byte{0x00, 0x45, 0x05, 0x4b}, ErrChecksum},
+		{"unknown opcode", []byte{0x00, 0x40, 0x00, 0x40}, ErrUnknownOpcode},
+		{"speed too high", []byte{0x00, 0x45, 0x20, 0x65}, ErrOutOfRange},
+		{"speed missing", []byte{0x00, 0x45, 0x45}, ErrMalformed},
+		{"speed too long", []byte{0x00, 0x45, 0x01, 0x01, 0x47}, ErrMalformed},
+		{"horn not a bool", []byte{0x00, 0x48, 0x02, 0x4a}, ErrMalformed},
+		{"speak without trailer", []byte{0x00, 0x4d, 0x04, 0x51}, ErrMalformed},
+		{"disconnect with arguments", []byte{0x00, 0x4b, 0x01, 0x00, 0x4c}, ErrMalformed},
+		{"unknown direction", []byte{0x00, 0x46, 0x03, 0x49}, ErrOutOfRange},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := Unmarshal(test.frame)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Unmarshal('% x') = %#v, %v, expected %v", test.frame, cmd, err, test.err)
+			}
+		})
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, golden := range goldenFrames {
+		f.Add(golden.frame)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0x00, 0x45, 0x05, 0x4b})
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		cmd, err := Unmarshal(frame)
+
+		validLength := len(frame) >= 3
+		validChecksum := validLength && frame[len(frame)-1] == Checksum(frame[1:len(frame)-1])
+		switch {
+		case !validLength:
+			if !errors.Is(err, ErrShortFrame) {
+				t.Fatalf("Unmarshal('% x') error = %v, expected %v", frame, err, ErrShortFrame)
+			}
+			return
+		case frame[0] != 0:
+			if !errors.Is(err, ErrBadLeader) {
+				t.Fatalf("Unmarshal('% x') error = %v, expected %v", frame, err, ErrBadLeader)
+			}
+			return
+		case !validChecksum:
+			if !errors.Is(err, ErrChecksum) {
+				t.Fatalf("Unmarshal('% x') error = %v, expected %v", frame, err, ErrChecksum)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		// anything accepted has to come back out byte for byte
+		again, err := Marshal(cmd)
+		if err != nil {
+			t.Fatalf("Marshal(%#v) of an unmarshalled frame failed: %v", cmd, err)
+		}
+		if !bytes.Equal(again, frame) {
+			t.Fatalf("round trip of '% x' gave '% x'", frame, again)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package lionchief
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit caps how fast frames are written to the train, so a flood of updates cannot
+// swamp the BLE link. Up to Burst frames go out back to back, after that they are spaced
+// out to FramesPerSecond. A FramesPerSecond of 0 disables the limit.
+type RateLimit struct {
+	FramesPerSecond float64
+	Burst           int
+}
+
+var DefaultRateLimit = RateLimit{
+	FramesPerSecond: 30,
+	Burst:           10,
+}
+
+// CommandStats counts what happened to the commands sent to the engine.
+// Coalesced commands were superseded by a newer one of the same kind before being written,
+// preempted ones were dropped by an emergency stop.
+type CommandStats struct {
+	Written   uint64
+	Failed    uint64
+	Coalesced uint64
+	Preempted uint64
+}
+
+// rateLimiter is a token bucket, only ever consumed from the command goroutine
+type rateLimiter struct {
+	lock    sync.Mutex
+	limit   RateLimit
+	tokens  float64
+	last    time.Time
+	written atomic.Uint64
+	failed  atomic.Uint64
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+	}
+}
+
+func (a *rateLimiter) set(limit RateLimit) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.limit = limit
+	a.tokens = min(a.tokens, float64(limit.Burst))
+}
+
+// refill tops the bucket up for the time since it was last touched, the lock must be held
+func (a *rateLimiter) refill(now time.Time) {
+	if !a.last.IsZero() {
+		a.tokens += now.Sub(a.last).Seconds() * a.limit.FramesPerSecond
+		a.tokens = min(a.tokens, float64(max(a.limit.Burst, 1)))
+	}
+	a.last = now
+}
+
+// delay is how long until the next frame may be written
+func (a *rateLimiter) delay(now time.Time) time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.limit.FramesPerSecond <= 0 {
+		return 0
+	}
+
+	a.refill(now)
+	if a.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - a.tokens) / a.limit.FramesPerSecond * float64(time.Second))
+}
+
+// take uses up a token, emergencies may push the bucket into debt
+func (a *rateLimiter) take(now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.limit.FramesPerSecond <= 0 {
+		return
+	}
+
+	a.refill(now)
+	a.tokens--
+}
+
+func (a *TrainEngine) SetRateLimit(limit RateLimit) {
+	a.limiter.set(limit)
+	// the command goroutine may be sleeping off the old limit
+	select {
+	case a.scheduler.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (a *TrainEngine) Stats() CommandStats {
+	a.scheduler.lock.Lock()
+	defer a.scheduler.lock.Unlock()
+	return CommandStats{
+		Written:   a.limiter.written.Load(),
+		Failed:    a.limiter.failed.Load(),
+		Coalesced: a.scheduler.coalesced,
+		Preempted: a.scheduler.preempted,
+	}
+}
@@ -0,0 +1,82 @@
+package lionchief
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func TestCommandCoalescing(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	release := holdCommands(engine)
+
+	var commands []queued
+	for speed := 1; speed <= 5; speed++ {
+		coalesced := uint64(speed - 1)
+		commands = append(commands, queue(t, engine, func() error { return engine.SetSpeed(speed) }, func() bool {
+			return pendingCount(engine) == 1 && engine.Stats().Coalesced == coalesced
+		}))
+	}
+	for count := 2; count <= 3; count++ {
+		commands = append(commands, queue(t, engine, func() error { return engine.SetHorn(true) }, func() bool {
+			return pendingCount(engine) == count
+		}))
+	}
+
+	release()
+	for _, command := range commands {
+		if err := command.wait(t); err != nil {
+			t.Fatalf("queued command failed: %v", err)
+		}
+	}
+	assertFrames(t, transport, protocol.SetSpeed{Speed: 5}, protocol.Horn{On: true}, protocol.Horn{On: true})
+	if coalesced := engine.Stats().Coalesced; coalesced != 4 {
+		t.Errorf("'%d' commands coalesced, expected '4'", coalesced)
+	}
+}
+
+// timedTransport notes when each frame was written
+type timedTransport struct {
+	*MemoryTransport
+	lock  sync.Mutex
+	times []time.Time
+}
+
+func (a *timedTransport) WriteFrame(frame []byte) error {
+	err := a.MemoryTransport.WriteFrame(frame)
+	a.lock.Lock()
+	a.times = append(a.times, time.Now())
+	a.lock.Unlock()
+	return err
+}
+
+func TestRateLimit(t *testing.T) {
+	transport := &timedTransport{MemoryTransport: NewMemoryTransport()}
+	engine := newTestEngineWith(t, transport, WithResetOnConnect(false), WithRateLimit(RateLimit{FramesPerSecond: 20, Burst: 2}))
+	// let the bucket fill up
+	time.Sleep(150 * time.Millisecond)
+
+	for i := 0; i < 6; i++ {
+		err := engine.SetLight(i%2 == 0)
+		if err != nil {
+			t.Fatalf("SetLight failed: %v", err)
+		}
+	}
+
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	if len(transport.times) != 6 {
+		t.Fatalf("'%d' frames written, expected '6'", len(transport.times))
+	}
+	if gap := transport.times[1].Sub(transport.times[0]); gap > 25*time.Millisecond {
+		t.Errorf("burst frames were '%v' apart", gap)
+	}
+	for i := 2; i < len(transport.times); i++ {
+		// 50ms apart at 20 a second, with some slack for the timer
+		if gap := transport.times[i].Sub(transport.times[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("frame '%d' written '%v' after the one before, expected at least 50ms", i, gap)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package lionchief
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+	"tinygo.org/x/bluetooth"
+)
+
+var (
+	ErrTrainNotRegistered     = errors.New("train is not registered")
+	ErrTrainAlreadyRegistered = errors.New("train is already registered")
+)
+
+// RegisteredTrain is everything remembered about one of our trains, so scripts can ask for
+// "Flyer" rather than copying MAC addresses around.
+type RegisteredTrain struct {
+	// Friendly name the train is looked up by
+	Name string `json:"name"`
+	// MAC address, when empty the train is found by scanning for AdvertisedName
+	Address        string `json:"address,omitempty"`
+	AdvertisedName string `json:"advertised_name,omitempty"`
+	RoadNumber     string `json:"road_number,omitempty"`
+	// Name of the engine profile to use instead of the one picked from the model number
+	Profile string `json:"profile,omitempty"`
+	// Volumes set after connecting, keyed 'master', 'horn', 'bell', 'speech' or 'engine'
+	Volumes map[string]int `json:"volumes,omitempty"`
+	// Speed cap enforced by the engine's interlocks, 0 is no limit beyond the profile's
+	MaxSpeed int `json:"max_speed,omitempty"`
+	// Vigilance settings for throttles driving this train, DefaultAlerterConfig when nil
+	Alerter   *RegisteredAlerter `json:"alerter,omitempty"`
+	LastState *TrainState        `json:"last_state,omitempty"`
+	LastSeen  time.Time          `json:"last_seen,omitempty"`
+}
+
+// RegisteredAlerter is AlerterConfig in a form fit for the registry file.
+type RegisteredAlerter struct {
+	IntervalSeconds float64 `json:"interval_s"`
+	WarningSeconds  float64 `json:"warning_s"`
+	// 'horn' or 'bell', the bell when empty
+	Warning string `json:"warning,omitempty"`
+}
+
+func (a RegisteredAlerter) config() AlerterConfig {
+	config := AlerterConfig{
+		Interval:      time.Duration(a.IntervalSeconds * float64(time.Second)),
+		WarningPeriod: time.Duration(a.WarningSeconds * float64(time.Second)),
+		Warning:       SOUNDTYPE_BELL,
+	}
+	if a.Warning == soundTypeName(SOUNDTYPE_HORN) {
+		config.Warning = SOUNDTYPE_HORN
+	}
+	return config
+}
+
+var registryVolumes = []string{"master", "horn", "bell", "speech", "engine"}
+
+func isRegistryVolume(name string) bool {
+	for _, volume := range registryVolumes {
+		if volume == name {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeSetters are the engine's volume setters by their name in registryVolumes
+func volumeSetters(engine *TrainEngine) map[string]func(int) error {
+	return map[string]func(int) error{
+		"master": engine.SetMainVolume,
+		"horn":   engine.SetHornVolume,
+		"bell":   engine.SetBellVolume,
+		"speech": engine.SetSpeechVolume,
+		"engine": engine.SetEngineVolume,
+	}
+}
+
+// maxVolume is the loudest the protocol allows for a volume in registryVolumes
+func maxVolume(name string) int {
+	if name == "master" {
+		return protocol.MaxMasterVolume
+	}
+	return protocol.MaxSoundLevel
+}
+
+func (a RegisteredTrain) validate() error {
+	if strings.TrimSpace(a.Name) == "" {
+		return fmt.Errorf("registered train needs a name")
+	}
+	if a.Address == "" && a.AdvertisedName == "" {
+		return fmt.Errorf("registered train '%s' needs an address or an advertised name", a.Name)
+	}
+	if a.Address != "" {
+		_, err := a.BluetoothAddress()
+		if err != nil {
+			return err
+		}
+	}
+	if a.Profile != "" {
+		if _, ok := LookupProfile(a.Profile); !ok {
+			return fmt.Errorf("registered train '%s' uses unknown profile '%s'", a.Name, a.Profile)
+		}
+	}
+	for key, volume := range a.Volumes {
+		if !isRegistryVolume(key) {
+			return fmt.Errorf("registered train '%s' has unknown volume '%s'", a.Name, key)
+		}
+		if volume < 0 || maxVolume(key) < volume {
+			return fmt.Errorf("registered train '%s' has invalid %s volume '%d', must be between '0' and '%d' (inclusive)", a.Name, key, volume, maxVolume(key))
+		}
+	}
+	if a.Alerter != nil {
+		if a.Alerter.IntervalSeconds <= 0 || a.Alerter.WarningSeconds < 0 {
+			return fmt.Errorf("registered train '%s' has invalid alerter timing", a.Name)
+		}
+		if a.Alerter.Warning != "" && a.Alerter.Warning != "horn" && a.Alerter.Warning != "bell" {
+			return fmt.Errorf("registered train '%s' has unknown alerter warning '%s', must be 'horn' or 'bell'", a.Name, a.Alerter.Warning)
+		}
+	}
+	if a.MaxSpeed < 0 || protocol.MaxSpeed < a.MaxSpeed {
+		return fmt.Errorf("invalid max speed, must be between '0' and '%d' (inclusive)", protocol.MaxSpeed)
+	}
+	return nil
+}
+
+func (a RegisteredTrain) BluetoothAddress() (bluetooth.Address, error) {
+	address := bluetooth.Address{}
+	address.Set(a.Address)
+	if !strings.EqualFold(address.String(), a.Address) {
+		return address, fmt.Errorf("invalid address '%s' for train '%s'", a.Address, a.Name)
+	}
+	return address, nil
+}
+
+// configure applies the per train settings, and the last state saved, to a freshly connected engine
+func (a RegisteredTrain) configure(engine *TrainEngine) error {
+	if a.Profile != "" {
+		profile, ok := LookupProfile(a.Profile)
+		if !ok {
+			return fmt.Errorf("registered train '%s' uses unknown profile '%s'", a.Name, a.Profile)
+		}
+		engine.SetProfile(profile)
+	}
+	if a.MaxSpeed > 0 {
+		interlocks := engine.Interlocks()
+		interlocks.MaxSpeed = a.MaxSpeed
+		engine.SetInterlocks(interlocks)
+	}
+
+	// pick up where the train was left, stopped and quiet whatever it was doing, unless the
+	// engine is told to always start from its defaults
+	if a.LastState != nil && engine.RestorePolicy() != RESTOREPOLICY_STOPPED {
+		last := *a.LastState
+		last.Speed = 0
+		last.HornActive = false
+		last.BellActive = false
+		err := engine.ApplyState(last)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the configured volumes win over whatever they were last left at
+	setters := volumeSetters(engine)
+	for _, name := range registryVolumes {
+		volume, ok := a.Volumes[name]
+		if !ok {
+			continue
+		}
+		err := setters[name](volume)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Registry is the set of known trains, stored as JSON. Every change is saved straight away.
+type Registry struct {
+	path   string
+	lock   sync.RWMutex
+	trains []RegisteredTrain
+}
+
+type registryFile struct {
+	Trains []RegisteredTrain `json:"trains"`
+}
+
+// UserRegistryPath is 'lionchief/trains.json' in the user config dir.
+func UserRegistryPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "lionchief", "trains.json"), nil
+}
+
+func LoadUserRegistry() (*Registry, error) {
+	path, err := UserRegistryPath()
+	if err != nil {
+		return nil, err
+	}
+	return LoadRegistry(path)
+}
+
+// LoadRegistry reads the registry at path. A missing file is an empty registry, created on
+// the first change.
+func LoadRegistry(path string) (*Registry, error) {
+	registry := Registry{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &registry, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := registryFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registry '%s': %w", path, err)
+	}
+	registry.trains = file.Trains
+	return &registry, nil
+}
+
+func (a *Registry) Path() string {
+	return a.path
+}
+
+// Trains returns a copy of every registered train
+func (a *Registry) Trains() []RegisteredTrain {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	trains := make([]RegisteredTrain, len(a.trains))
+	copy(trains, a.trains)
+	return trains
+}
+
+// Lookup finds a train by its friendly name, ignoring case, or by its advertised name.
+func (a *Registry) Lookup(name string) (RegisteredTrain, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	index := a.find(name)
+	if index < 0 {
+		return RegisteredTrain{}, false
+	}
+	return a.trains[index], true
+}
+
+func (a *Registry) LookupByAddress(address bluetooth.Address) (RegisteredTrain, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	for _, train := range a.trains {
+		if strings.EqualFold(train.Address, address.String()) {
+			return train, true
+		}
+	}
+	return RegisteredTrain{}, false
+}
+
+// find returns the index of the named train, or -1. Must be called with the lock held.
+func (a *Registry) find(name string) int {
+	for i, train := range a.trains {
+		if strings.EqualFold(train.Name, name) {
+			return i
+		}
+	}
+	for i, train := range a.trains {
+		if train.AdvertisedName != "" && train.AdvertisedName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add registers a new train, failing if one of the same name or address already is.
+func (a *Registry) Add(train RegisteredTrain) error {
+	err := train.validate()
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, existing := range a.trains {
+		if strings.EqualFold(existing.Name, train.Name) {
+			return fmt.Errorf("%w, name '%s' is taken", ErrTrainAlreadyRegistered, train.Name)
+		}
+		if train.Address != "" && strings.EqualFold(existing.Address, train.Address) {
+			return fmt.Errorf("%w, address '%s' belongs to '%s'", ErrTrainAlreadyRegistered, train.Address, existing.Name)
+		}
+	}
+	a.trains = append(a.trains, train)
+	return a.save()
+}
+
+// Update replaces the registered train of the same name, failing if another train has its address.
+func (a *Registry) Update(train RegisteredTrain) error {
+	err := train.validate()
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	index := a.find(train.Name)
+	if index < 0 {
+		return fmt.Errorf("%w: '%s'", ErrTrainNotRegistered, train.Name)
+	}
+	for i, existing := range a.trains {
+		if i != index && train.Address != "" && strings.EqualFold(existing.Address, train.Address) {
+			return fmt.Errorf("%w, address '%s' belongs to '%s'", ErrTrainAlreadyRegistered, train.Address, existing.Name)
+		}
+	}
+	a.trains[index] = train
+	return a.save()
+}
+
+func (a *Registry) Remove(name string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	index := a.find(name)
+	if index < 0 {
+		return fmt.Errorf("%w: '%s'", ErrTrainNotRegistered, name)
+	}
+	a.trains = append(a.trains[:index], a.trains[index+1:]...)
+	return a.save()
+}
+
+// SaveState records the last known state of the named train.
+func (a *Registry) SaveState(name string, state TrainState) error {
+	return a.modify(name, func(existing *RegisteredTrain) {
+		existing.LastState = &state
+		existing.LastSeen = time.Now()
+	})
+}
+
+func (a *Registry) modify(name string, change func(*RegisteredTrain)) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	index := a.find(name)
+	if index < 0 {
+		return fmt.Errorf("%w: '%s'", ErrTrainNotRegistered, name)
+	}
+	change(&a.trains[index])
+	return a.save()
+}
+
+// save writes the registry next to its final path and renames it over, so a crash never
+// leaves half a file behind. Must be called with the lock held.
+func (a *Registry) save() error {
+	data, err := json.MarshalIndent(registryFile{Trains: a.trains}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(a.path), 0o755)
+	if err != nil {
+		return err
+	}
+	temp := a.path + ".tmp"
+	err = os.WriteFile(temp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, a.path)
+}
+
+// NewSimulator connects to the named train and applies its profile and volumes. Trains
+// registered without an address are scanned for by advertised name, and the address found
+// is remembered.
+func (a *Registry) NewSimulator(ctx context.Context, name string, opts ...Option) (*TrainSimulator, error) {
+	train, ok := a.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrTrainNotRegistered, name)
+	}
+
+	if train.Address == "" {
+		config := newEngineConfig(opts)
+		advertisement, err := NewScanner(config.adapter, WithLogger(config.logger)).FindByName(ctx, train.AdvertisedName)
+		if err != nil {
+			return nil, err
+		}
+		train.Address = advertisement.Address.String()
+		err = a.Update(train)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	address, err := train.BluetoothAddress()
+	if err != nil {
+		return nil, err
+	}
+	simulator, err := NewSimulatorContext(ctx, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = train.configure(simulator.engine)
+	if err != nil {
+		simulator.Disconnect()
+		return nil, err
+	}
+	simulator.registry = a
+	simulator.registered = train.Name
+	return simulator, nil
+}
+
+// NewSimulatorByName connects to a train from the user's registry, see Registry.NewSimulator.
+func NewSimulatorByName(ctx context.Context, name string, opts ...Option) (*TrainSimulator, error) {
+	// the registry may name a profile that only exists in the user's config
+	err := LoadUserProfiles()
+	if err != nil {
+		return nil, err
+	}
+	registry, err := LoadUserRegistry()
+	if err != nil {
+		return nil, err
+	}
+	return registry.NewSimulator(ctx, name, opts...)
+}
@@ -0,0 +1,180 @@
+package lionchief
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// testTrain is a registered train that passes validation
+func testTrain(name string, address string) RegisteredTrain {
+	return RegisteredTrain{Name: name, Address: address}
+}
+
+func TestRegisteredTrainValidate(t *testing.T) {
+	for _, test := range []struct {
+		reason string
+		change func(train *RegisteredTrain)
+	}{
+		{"no name", func(a *RegisteredTrain) { a.Name = " " }},
+		{"no address or advertised name", func(a *RegisteredTrain) { a.Address = "" }},
+		{"invalid address", func(a *RegisteredTrain) { a.Address = "not an address" }},
+		{"unknown profile", func(a *RegisteredTrain) { a.Profile = "No Such Train" }},
+		{"unknown volume", func(a *RegisteredTrain) { a.Volumes = map[string]int{"whistle": 3} }},
+		{"master volume too loud", func(a *RegisteredTrain) { a.Volumes = map[string]int{"master": protocol.MaxMasterVolume + 1} }},
+		{"horn volume too loud", func(a *RegisteredTrain) { a.Volumes = map[string]int{"horn": protocol.MaxSoundLevel + 1} }},
+		{"negative volume", func(a *RegisteredTrain) { a.Volumes = map[string]int{"bell": -1} }},
+		{"alerter without interval", func(a *RegisteredTrain) { a.Alerter = &RegisteredAlerter{WarningSeconds: 5} }},
+		{"unknown alerter warning", func(a *RegisteredTrain) {
+			a.Alerter = &RegisteredAlerter{IntervalSeconds: 60, WarningSeconds: 5, Warning: "whistle"}
+		}},
+		{"max speed too fast", func(a *RegisteredTrain) { a.MaxSpeed = protocol.MaxSpeed + 1 }},
+	} {
+		t.Run(test.reason, func(t *testing.T) {
+			train := testTrain("Flyer", "44:A6:E5:00:00:01")
+			test.change(&train)
+			if err := train.validate(); err == nil {
+				t.Errorf("train with %s passed validation", test.reason)
+			}
+		})
+	}
+
+	train := testTrain("Flyer", "")
+	train.AdvertisedName = "LC-0-1-0429-754D"
+	train.Profile = "Pennsylvania Flyer"
+	train.Volumes = map[string]int{"master": protocol.MaxMasterVolume, "engine": protocol.MaxSoundLevel}
+	train.Alerter = &RegisteredAlerter{IntervalSeconds: 60, WarningSeconds: 5, Warning: "horn"}
+	train.MaxSpeed = 10
+	if err := train.validate(); err != nil {
+		t.Errorf("valid train failed validation: %v", err)
+	}
+}
+
+func TestRegistrySaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lionchief", "trains.json")
+	registry, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatalf("LoadRegistry of a missing file failed: %v", err)
+	}
+	if trains := registry.Trains(); len(trains) != 0 {
+		t.Fatalf("missing registry has '%d' trains, expected none", len(trains))
+	}
+
+	flyer := testTrain("Flyer", "44:A6:E5:00:00:01")
+	flyer.Volumes = map[string]int{"horn": 3}
+	err = registry.Add(flyer)
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	err = registry.Add(testTrain("Switcher", "44:A6:E5:00:00:02"))
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	err = registry.SaveState("flyer", TrainState{Version: TrainStateVersion, Speed: 4, Reverse: true})
+	if err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+
+	loaded, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatalf("LoadRegistry failed: %v", err)
+	}
+	trains := loaded.Trains()
+	if len(trains) != 2 || trains[0].Name != "Flyer" || trains[1].Name != "Switcher" {
+		t.Fatalf("loaded trains '%+v', expected Flyer and Switcher", trains)
+	}
+	if trains[0].Volumes["horn"] != 3 {
+		t.Errorf("loaded horn volume '%d', expected '3'", trains[0].Volumes["horn"])
+	}
+	if trains[0].LastState == nil || trains[0].LastState.Speed != 4 || !trains[0].LastState.Reverse {
+		t.Errorf("loaded last state '%+v', expected speed '4' in reverse", trains[0].LastState)
+	}
+	if trains[0].LastSeen.IsZero() {
+		t.Error("loaded train was never seen")
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	err = os.WriteFile(path, []byte("{"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRegistry(path); err == nil {
+		t.Error("LoadRegistry of a corrupt file succeeded")
+	}
+}
+
+func TestRegistryUniqueness(t *testing.T) {
+	registry, err := LoadRegistry(filepath.Join(t.TempDir(), "trains.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, train := range []RegisteredTrain{testTrain("Flyer", "44:A6:E5:00:00:01"), testTrain("Switcher", "44:A6:E5:00:00:02")} {
+		err = registry.Add(train)
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	for _, test := range []struct {
+		reason   string
+		call     func() error
+		expected error
+	}{
+		{"same name", func() error { return registry.Add(testTrain("FLYER", "44:A6:E5:00:00:03")) }, ErrTrainAlreadyRegistered},
+		{"same address", func() error { return registry.Add(testTrain("Mogul", "44:A6:E5:00:00:01")) }, ErrTrainAlreadyRegistered},
+		{"update to a taken address", func() error { return registry.Update(testTrain("Switcher", "44:A6:E5:00:00:01")) }, ErrTrainAlreadyRegistered},
+		{"update of an unknown train", func() error { return registry.Update(testTrain("Mogul", "44:A6:E5:00:00:03")) }, ErrTrainNotRegistered},
+		{"update keeping its own address", func() error { return registry.Update(testTrain("Flyer", "44:A6:E5:00:00:01")) }, nil},
+		{"update to a free address", func() error { return registry.Update(testTrain("Switcher", "44:A6:E5:00:00:03")) }, nil},
+	} {
+		t.Run(test.reason, func(t *testing.T) {
+			err := test.call()
+			if !errors.Is(err, test.expected) {
+				t.Errorf("%s returned %v, expected %v", test.reason, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestRegisteredTrainConfigure(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		policy   RestorePolicy
+		expected []protocol.Command
+	}{
+		{"restores the last state", RESTOREPOLICY_ALL_BUT_SPEED, []protocol.Command{
+			protocol.Lights{On: false},
+			protocol.SetSoundVolume{Type: protocol.SoundBell, Level: 2},
+			protocol.SetDirection{Direction: protocol.DirectionReverse},
+			protocol.SetSoundVolume{Type: protocol.SoundHorn, Level: 3},
+		}},
+		{"starts from the defaults", RESTOREPOLICY_STOPPED, []protocol.Command{
+			protocol.SetSoundVolume{Type: protocol.SoundHorn, Level: 3},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine, transport := newTestEngine(t, WithRestorePolicy(test.policy))
+			// left moving with the horn going, it must come back stopped and quiet
+			last := engine.Snapshot()
+			last.Light = false
+			last.VolumeBell = 2
+			last.Reverse = true
+			last.Speed = 9
+			last.HornActive = true
+
+			train := testTrain("Flyer", "44:A6:E5:00:00:01")
+			train.Volumes = map[string]int{"horn": 3}
+			train.LastState = &last
+			err := train.configure(engine)
+			if err != nil {
+				t.Fatalf("configure failed: %v", err)
+			}
+			assertFrames(t, transport, test.expected...)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package lionchief
+
+// RestorePolicy decides how much of the desired TrainState is replayed to the train after
+// a reconnect, the train itself comes back in its power-on defaults.
+type RestorePolicy int
+
+const (
+	// Replay everything, including getting back up to speed
+	RESTOREPOLICY_ALL RestorePolicy = iota
+	// Replay direction, lights and volumes but come back at speed 0
+	RESTOREPOLICY_ALL_BUT_SPEED
+	// Reset the train to the engine defaults, as on a fresh connect
+	RESTOREPOLICY_STOPPED
+)
+
+func (a RestorePolicy) String() string {
+	switch a {
+	case RESTOREPOLICY_ALL:
+		return "All"
+	case RESTOREPOLICY_ALL_BUT_SPEED:
+		return "AllButSpeed"
+	case RESTOREPOLICY_STOPPED:
+		return "Stopped"
+	}
+	return "Unknown"
+}
+
+func (a *TrainEngine) SetRestorePolicy(policy RestorePolicy) {
+	a.connLock.Lock()
+	defer a.connLock.Unlock()
+	a.restorePolicy = policy
+}
+
+func (a *TrainEngine) RestorePolicy() RestorePolicy {
+	a.connLock.Lock()
+	defer a.connLock.Unlock()
+	return a.restorePolicy
+}
+
+// restoreState replays the desired state to a freshly reconnected train
+func (a *TrainEngine) restoreState() error {
+	policy := a.RestorePolicy()
+	a.logger.Printf("Restoring train state with policy '%v'", policy)
+	if policy == RESTOREPOLICY_STOPPED {
+		return a.ResetState()
+	}
+
+	desired := a.Snapshot()
+	if policy == RESTOREPOLICY_ALL_BUT_SPEED {
+		desired.Speed = 0
+	}
+
+	// direction has to be right before the train starts moving again, so speed goes last
+	steps := []func() error{
+		func() error { return a.SetReverse(desired.Reverse) },
+		func() error { return a.SetLight(desired.Light) },
+		func() error { return a.SetMainVolume(desired.Volume) },
+		func() error { return a.SetHornVolume(desired.VolumeHorn) },
+		func() error { return a.SetEngineVolume(desired.VolumeEngine) },
+		func() error { return a.SetBellVolume(desired.VolumeBell) },
+		func() error { return a.SetSpeechVolume(desired.VolumeSpeech) },
+		func() error { return a.SetHornPitch(desired.PitchHorn) },
+		func() error { return a.SetEnginePitch(desired.PitchEngine) },
+		func() error { return a.SetBellPitch(desired.PitchBell) },
+		func() error { return a.SetSpeechPitch(desired.PitchSpeech) },
+		func() error { return a.SetSpeed(desired.Speed) },
+	}
+	for _, step := range steps {
+		err := step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package lionchief
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// dropLink takes the link down and waits for the engine to reconnect and restore
+func dropLink(t *testing.T, engine *TrainEngine, transport *MemoryTransport) {
+	t.Helper()
+	reconnected := make(chan struct{}, 1)
+	engine.OnConnectionChange(func(change ConnectionChange) {
+		if change.To == CONNECTIONSTATE_CONNECTED {
+			reconnected <- struct{}{}
+		}
+	})
+	transport.Reset()
+	transport.SetConnected(false)
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the engine to reconnect")
+	}
+}
+
+func TestRestoreAfterReconnect(t *testing.T) {
+	restored := func(speed int) []protocol.Command {
+		return []protocol.Command{
+			protocol.SetDirection{Direction: protocol.DirectionReverse},
+			protocol.Lights{On: false},
+			protocol.MasterVolume{Level: 7},
+			protocol.SetSoundVolume{Type: protocol.SoundHorn, Level: 3},
+			protocol.SetSoundVolume{Type: protocol.SoundEngine, Level: 0},
+			protocol.SetSoundVolume{Type: protocol.SoundBell, Level: 7},
+			protocol.SetSoundVolume{Type: protocol.SoundSpeech, Level: 7},
+			protocol.SetSoundPitch{Type: protocol.SoundHorn, Pitch: protocol.PitchHigh},
+			protocol.SetSoundPitch{Type: protocol.SoundEngine, Pitch: protocol.PitchNormal},
+			protocol.SetSoundPitch{Type: protocol.SoundBell, Pitch: protocol.PitchNormal},
+			protocol.SetSoundPitch{Type: protocol.SoundSpeech, Pitch: protocol.PitchNormal},
+			protocol.SetSpeed{Speed: uint8(speed)},
+		}
+	}
+	for _, test := range []struct {
+		policy   RestorePolicy
+		expected []protocol.Command
+	}{
+		{RESTOREPOLICY_ALL, restored(10)},
+		{RESTOREPOLICY_ALL_BUT_SPEED, restored(0)},
+		{RESTOREPOLICY_STOPPED, []protocol.Command{
+			protocol.SetSpeed{Speed: 0},
+			protocol.SetDirection{Direction: protocol.DirectionForward},
+			protocol.Lights{On: true},
+			protocol.MasterVolume{Level: 7},
+			protocol.SetSoundVolume{Type: protocol.SoundHorn, Level: 7},
+			protocol.SetSoundVolume{Type: protocol.SoundEngine, Level: 0},
+			protocol.SetSoundVolume{Type: protocol.SoundBell, Level: 7},
+			protocol.SetSoundVolume{Type: protocol.SoundSpeech, Level: 7},
+		}},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			engine, transport := newTestEngine(t, WithRestorePolicy(test.policy))
+			for _, step := range []func() error{
+				func() error { return engine.SetReverse(true) },
+				func() error { return engine.SetLight(false) },
+				func() error { return engine.SetHornVolume(3) },
+				func() error { return engine.SetHornPitch(SoundPitch(SOUNDPITCH_HIGH)) },
+				func() error { return engine.SetSpeed(10) },
+			} {
+				if err := step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			dropLink(t, engine, transport)
+			assertFrames(t, transport, test.expected...)
+			if transport.Reconnects() != 1 {
+				t.Errorf("'%d' reconnects attempted, expected '1'", transport.Reconnects())
+			}
+		})
+	}
+}
+
+func TestRestoreAfterFailedReconnects(t *testing.T) {
+	engine, transport := newTestEngine(t, WithRestorePolicy(RESTOREPOLICY_ALL), WithReconnectPolicy(ReconnectPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     1,
+	}))
+	err := engine.SetSpeed(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport.FailReconnects(2)
+	dropLink(t, engine, transport)
+	if transport.Reconnects() != 3 {
+		t.Errorf("'%d' reconnects attempted, expected '3'", transport.Reconnects())
+	}
+	// the state is only replayed once the link is really back
+	frames := transport.Frames()
+	last, err := protocol.Unmarshal(frames[len(frames)-1])
+	if err != nil || last != (protocol.SetSpeed{Speed: 6}) {
+		t.Fatalf("frames written:\n%s", describeFrames(frames))
+	}
+}
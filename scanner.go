@@ -0,0 +1,259 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// NamePrefix is how every LionChief locomotive starts its advertised name, e.g. 'LC-0-1-0429-754D'
+const NamePrefix = "LC-"
+
+var ErrTrainNotFound = errors.New("train not found")
+
+// Advertisement is a single LionChief advertisement seen while scanning.
+type Advertisement struct {
+	Address          bluetooth.Address
+	RSSI             int16
+	LocalName        string
+	Identity         *DeviceIdentity
+	TxPower          *int8
+	ManufacturerData []bluetooth.ManufacturerDataElement
+	Seen             time.Time
+}
+
+type ScanEventType int
+
+const (
+	SCANEVENT_APPEARED ScanEventType = iota
+	SCANEVENT_UPDATED
+	SCANEVENT_DISAPPEARED
+)
+
+type ScanEvent struct {
+	Type          ScanEventType
+	Advertisement Advertisement
+}
+
+// Scanner finds LionChief locomotives, by their read/write service or their 'LC-' name.
+type Scanner struct {
+	adapter *bluetooth.Adapter
+	logger  *log.Logger
+	// Trains not heard from for this long are reported as disappeared by Watch,
+	// DefaultScanExpiry when not positive
+	Expiry time.Duration
+}
+
+const DefaultScanExpiry = 10 * time.Second
+
+// NewScanner scans with adapter, only the logger is taken from opts.
+func NewScanner(adapter *bluetooth.Adapter, opts ...Option) *Scanner {
+	return &Scanner{
+		adapter: adapter,
+		logger:  newEngineConfig(opts).logger,
+		Expiry:  DefaultScanExpiry,
+	}
+}
+
+// IsLionChief reports whether an advertisement belongs to a LionChief locomotive.
+func IsLionChief(result bluetooth.ScanResult) bool {
+	return result.HasServiceUUID(ReadWriteService) || strings.HasPrefix(result.LocalName(), NamePrefix)
+}
+
+func newAdvertisement(result bluetooth.ScanResult) Advertisement {
+	advertisement := Advertisement{
+		Address:   result.Address,
+		RSSI:      result.RSSI,
+		LocalName: result.LocalName(),
+		Seen:      time.Now(),
+	}
+	// trains found by service uuid alone may not advertise a parseable name
+	identity, err := ParseDeviceName(advertisement.LocalName)
+	if err == nil {
+		advertisement.Identity = &identity
+	}
+
+	// the payload is only valid during the scan callback, so copy everything we keep
+	for _, element := range result.ManufacturerData() {
+		data := make([]byte, len(element.Data))
+		copy(data, element.Data)
+		advertisement.ManufacturerData = append(advertisement.ManufacturerData, bluetooth.ManufacturerDataElement{
+			CompanyID: element.CompanyID,
+			Data:      data,
+		})
+	}
+	advertisement.TxPower = parseTxPower(result.Bytes())
+	return advertisement
+}
+
+// parseTxPower finds the TX power level field in a raw advertisement, not every platform
+// hands out the raw bytes so this is often nil
+func parseTxPower(raw []byte) *int8 {
+	for len(raw) > 1 {
+		fieldLength := int(raw[0])
+		if fieldLength == 0 || fieldLength+1 > len(raw) {
+			return nil
+		}
+		// 0x0a is the 'Tx Power Level' AD type
+		if raw[1] == 0x0a && fieldLength == 2 {
+			power := int8(raw[2])
+			return &power
+		}
+		raw = raw[fieldLength+1:]
+	}
+	return nil
+}
+
+// Scan reports every LionChief advertisement to handler until ctx is done.
+// Returning false from handler stops the scan early.
+func (a *Scanner) Scan(ctx context.Context, handler func(Advertisement) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	err := a.adapter.Enable()
+	if err != nil {
+		return fmt.Errorf("failed to enable adapter: %w", err)
+	}
+
+	// Scan blocks until StopScan, so stop it from the side once we are done. StopScan does
+	// nothing when the scan has not started yet, so keep at it until Scan returns.
+	scanDone := make(chan struct{})
+	defer close(scanDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-scanDone:
+			return
+		}
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			a.adapter.StopScan()
+			select {
+			case <-scanDone:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	a.logger.Println("Scanning for trains")
+	err = a.adapter.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
+		if ctx.Err() != nil {
+			adapter.StopScan()
+			return
+		}
+		if !IsLionChief(result) {
+			return
+		}
+		if !handler(newAdvertisement(result)) {
+			cancel()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Find scans until match accepts an advertisement or ctx is done.
+func (a *Scanner) Find(ctx context.Context, match func(Advertisement) bool) (Advertisement, error) {
+	var found *Advertisement
+	err := a.Scan(ctx, func(advertisement Advertisement) bool {
+		if match(advertisement) {
+			found = &advertisement
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return Advertisement{}, err
+	}
+	if found == nil {
+		if ctx.Err() != nil {
+			return Advertisement{}, fmt.Errorf("%w: %w", ErrTrainNotFound, ctx.Err())
+		}
+		return Advertisement{}, ErrTrainNotFound
+	}
+	return *found, nil
+}
+
+func (a *Scanner) FindByName(ctx context.Context, name string) (Advertisement, error) {
+	return a.Find(ctx, func(advertisement Advertisement) bool {
+		return advertisement.LocalName == name
+	})
+}
+
+func (a *Scanner) FindByAddress(ctx context.Context, address bluetooth.Address) (Advertisement, error) {
+	return a.Find(ctx, func(advertisement Advertisement) bool {
+		return advertisement.Address == address
+	})
+}
+
+// Watch reports trains as they appear, update and disappear (not heard from for Expiry)
+// until ctx is done.
+func (a *Scanner) Watch(ctx context.Context, handler func(ScanEvent)) error {
+	// the expiry checks stop with the scan, however it ends
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lock sync.Mutex
+	seen := map[bluetooth.Address]Advertisement{}
+
+	expiry := a.Expiry
+	if expiry <= 0 {
+		expiry = DefaultScanExpiry
+	}
+	expiryDone := make(chan struct{})
+	go func() {
+		defer close(expiryDone)
+		ticker := time.NewTicker(max(expiry/2, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				lock.Lock()
+				for address, advertisement := range seen {
+					if now.Sub(advertisement.Seen) > expiry {
+						delete(seen, address)
+						handler(ScanEvent{Type: SCANEVENT_DISAPPEARED, Advertisement: advertisement})
+					}
+				}
+				lock.Unlock()
+			}
+		}
+	}()
+
+	err := a.Scan(ctx, func(advertisement Advertisement) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		eventType := SCANEVENT_UPDATED
+		if _, ok := seen[advertisement.Address]; !ok {
+			eventType = SCANEVENT_APPEARED
+		}
+		seen[advertisement.Address] = advertisement
+		handler(ScanEvent{Type: eventType, Advertisement: advertisement})
+		return true
+	})
+	// handler is never called once Watch has returned
+	cancel()
+	<-expiryDone
+	return err
+}
+
+// FindTrain scans the default adapter for the train advertising name.
+func FindTrain(ctx context.Context, name string) (Advertisement, error) {
+	return NewScanner(bluetooth.DefaultAdapter).FindByName(ctx, name)
+}
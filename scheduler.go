@@ -0,0 +1,270 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+var (
+	ErrPreempted     = errors.New("command preempted by emergency stop")
+	ErrEmergencyStop = errors.New("emergency stop in progress")
+)
+
+// Priority decides which pending command the command goroutine writes next, lower goes first.
+type Priority int
+
+const (
+	PRIORITY_EMERGENCY Priority = iota
+	PRIORITY_MOTION
+	PRIORITY_SOUND
+	PRIORITY_COSMETIC
+)
+
+const priorityCount = 4
+
+func (a Priority) String() string {
+	switch a {
+	case PRIORITY_EMERGENCY:
+		return "Emergency"
+	case PRIORITY_MOTION:
+		return "Motion"
+	case PRIORITY_SOUND:
+		return "Sound"
+	case PRIORITY_COSMETIC:
+		return "Cosmetic"
+	}
+	return "Unknown"
+}
+
+// coalesceKey identifies commands that supersede each other, only the latest of a kind
+// matters. Momentary commands (horn, bell, speech) never coalesce.
+func coalesceKey(cmd protocol.Command) string {
+	switch c := cmd.(type) {
+	case protocol.SetSpeed:
+		return "speed"
+	case protocol.SetDirection:
+		return "direction"
+	case protocol.Lights:
+		return "lights"
+	case protocol.MasterVolume:
+		return "master_volume"
+	case protocol.SetSoundVolume:
+		return fmt.Sprintf("volume_%d", c.Type)
+	case protocol.SetSoundPitch:
+		return fmt.Sprintf("pitch_%d", c.Type)
+	}
+	return ""
+}
+
+// commandPriority classifies a command, custom frames are treated as sound so an emergency
+// stop never throws them away
+func commandPriority(cmd protocol.Command) Priority {
+	switch cmd.(type) {
+	case protocol.SetSpeed, protocol.SetDirection:
+		return PRIORITY_MOTION
+	case protocol.Lights:
+		return PRIORITY_COSMETIC
+	}
+	return PRIORITY_SOUND
+}
+
+// commandScheduler holds pending commands in one FIFO per priority.
+// Everything but emergencies counts against a bounded number of slots, so callers block
+// once the train cannot keep up, while an emergency stop is always accepted.
+type commandScheduler struct {
+	lock    sync.Mutex
+	pending [priorityCount][]*commandRequest
+	slots   chan struct{}
+	wake    chan struct{}
+	// emergency stops so far, guarded requests made before the latest one are refused
+	stops     uint64
+	coalesced uint64
+	preempted uint64
+	// set while the watchdog is tripped, motion requests other than its own are refused until
+	// it is fed again
+	halted bool
+}
+
+func newCommandScheduler(size int) *commandScheduler {
+	return &commandScheduler{
+		slots: make(chan struct{}, size),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (a *commandScheduler) push(request *commandRequest) error {
+	a.lock.Lock()
+	stopped := request.guarded && request.stops != a.stops
+	halted := a.halted && request.priority == PRIORITY_MOTION && !request.watchdog
+	if stopped || halted {
+		a.lock.Unlock()
+		a.release(request)
+		return ErrEmergencyStop
+	}
+	superseded := a.coalesce(request)
+	if superseded == nil {
+		a.pending[request.priority] = append(a.pending[request.priority], request)
+	}
+	a.lock.Unlock()
+
+	if superseded != nil {
+		// the newer command replaces it, so as far as its caller is concerned it is done
+		a.release(superseded)
+		superseded.done <- nil
+	}
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// coalesce swaps request in for a pending command it supersedes, returning the superseded one.
+// Motion only ever coalesces with the newest pending motion command, so a stop queued ahead of
+// a direction change can never be skipped. Must be called with the lock held.
+func (a *commandScheduler) coalesce(request *commandRequest) *commandRequest {
+	if request.key == "" {
+		return nil
+	}
+
+	pending := a.pending[request.priority]
+	first := 0
+	if request.priority == PRIORITY_MOTION {
+		first = len(pending) - 1
+	}
+	for i := max(first, 0); i < len(pending); i++ {
+		if pending[i].key == request.key {
+			superseded := pending[i]
+			pending[i] = request
+			a.coalesced++
+			return superseded
+		}
+	}
+	return nil
+}
+
+// hasPending reports whether anything is waiting, and whether that includes an emergency
+func (a *commandScheduler) hasPending() (pending bool, emergency bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for priority := range a.pending {
+		if len(a.pending[priority]) > 0 {
+			return true, priority == int(PRIORITY_EMERGENCY)
+		}
+	}
+	return false, false
+}
+
+// next pops the most urgent pending command, or nil when there is none
+func (a *commandScheduler) next() *commandRequest {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for priority := range a.pending {
+		if len(a.pending[priority]) > 0 {
+			request := a.pending[priority][0]
+			a.pending[priority] = a.pending[priority][1:]
+			a.release(request)
+			return request
+		}
+	}
+	return nil
+}
+
+// emergencyStop refuses guarded requests made before now and drops all pending motion
+func (a *commandScheduler) emergencyStop() int {
+	a.lock.Lock()
+	a.stops++
+	a.lock.Unlock()
+	return a.flush(PRIORITY_MOTION, ErrPreempted)
+}
+
+// halt is an emergency stop that goes on refusing guarded requests until resume
+func (a *commandScheduler) halt() int {
+	a.lock.Lock()
+	a.halted = true
+	a.lock.Unlock()
+	return a.emergencyStop()
+}
+
+func (a *commandScheduler) resume() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.halted = false
+}
+
+func (a *commandScheduler) emergencyStops() uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.stops
+}
+
+// flush drops every pending command of the given priority, answering them with err
+func (a *commandScheduler) flush(priority Priority, err error) int {
+	a.lock.Lock()
+	flushed := a.pending[priority]
+	a.pending[priority] = nil
+	if errors.Is(err, ErrPreempted) {
+		a.preempted += uint64(len(flushed))
+	}
+	a.lock.Unlock()
+
+	for _, request := range flushed {
+		a.release(request)
+		request.done <- err
+	}
+	return len(flushed)
+}
+
+func (a *commandScheduler) flushAll(err error) {
+	for priority := range a.pending {
+		a.flush(Priority(priority), err)
+	}
+}
+
+func (a *commandScheduler) release(request *commandRequest) {
+	if request.priority != PRIORITY_EMERGENCY {
+		<-a.slots
+	}
+}
+
+// EmergencyStop stops the train ahead of everything else that is waiting to be sent.
+// Pending motion commands are thrown away (their callers get ErrPreempted) and ramps in
+// progress notice via EmergencyStops and give up.
+func (a *TrainEngine) EmergencyStop() error {
+	return a.emergencyStopContext(context.Background())
+}
+
+// emergencyStopContext is EmergencyStop, giving up waiting for the stop to be written when
+// ctx is done
+func (a *TrainEngine) emergencyStopContext(ctx context.Context) error {
+	a.logger.Println("EmergencyStop")
+	defer a.logger.Println("EmergencyStop-Done")
+	flushed := a.scheduler.emergencyStop()
+	if flushed > 0 {
+		a.logger.Printf("Emergency stop dropped '%d' pending motion commands", flushed)
+	}
+
+	cmd := protocol.SetSpeed{Speed: 0}
+	frame, err := protocol.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	return a.submitContext(ctx, &commandRequest{cmd: cmd, frame: frame, priority: PRIORITY_EMERGENCY})
+}
+
+// EmergencyStops counts emergency stops so far. Long running motion remembers it when it starts
+// and passes it to SetSpeedUnlessStopped, so it cannot restart a train that was stopped since.
+func (a *TrainEngine) EmergencyStops() uint64 {
+	return a.scheduler.emergencyStops()
+}
+
+// SetSpeedUnlessStopped is SetSpeed, except it fails with ErrEmergencyStop if there has been an
+// emergency stop since EmergencyStops returned emergencyStops, or the watchdog is tripped.
+func (a *TrainEngine) SetSpeedUnlessStopped(speed int, emergencyStops uint64) error {
+	return a.setSpeed(speed, true, emergencyStops)
+}
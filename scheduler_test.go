@@ -0,0 +1,61 @@
+package lionchief
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func TestCommandPriority(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	release := holdCommands(engine)
+
+	lights := queue(t, engine, func() error { return engine.SetLight(false) }, func() bool { return pendingCount(engine) == 1 })
+	horn := queue(t, engine, func() error { return engine.SetHorn(true) }, func() bool { return pendingCount(engine) == 2 })
+	speed := queue(t, engine, func() error { return engine.SetSpeed(3) }, func() bool { return pendingCount(engine) == 3 })
+	assertFrames(t, transport)
+
+	release()
+	for _, command := range []queued{lights, horn, speed} {
+		if err := command.wait(t); err != nil {
+			t.Fatalf("queued command failed: %v", err)
+		}
+	}
+	assertFrames(t, transport, protocol.SetSpeed{Speed: 3}, protocol.Horn{On: true}, protocol.Lights{On: false})
+}
+
+func TestEmergencyStopPreempts(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	stopsBefore := engine.EmergencyStops()
+	release := holdCommands(engine)
+	defer release()
+
+	speed := queue(t, engine, func() error { return engine.SetSpeed(10) }, func() bool { return pendingCount(engine) == 1 })
+	lights := queue(t, engine, func() error { return engine.SetLight(false) }, func() bool { return pendingCount(engine) == 2 })
+
+	// goes out ahead of the held commands, rate limit or not
+	err := engine.EmergencyStop()
+	if err != nil {
+		t.Fatalf("EmergencyStop failed: %v", err)
+	}
+	if err := speed.wait(t); !errors.Is(err, ErrPreempted) {
+		t.Fatalf("pending SetSpeed returned %v, expected %v", err, ErrPreempted)
+	}
+	assertFrames(t, transport, protocol.SetSpeed{Speed: 0})
+
+	err = engine.SetSpeedUnlessStopped(5, stopsBefore)
+	if !errors.Is(err, ErrEmergencyStop) {
+		t.Fatalf("SetSpeedUnlessStopped after an emergency stop returned %v, expected %v", err, ErrEmergencyStop)
+	}
+
+	// only motion is thrown away
+	release()
+	if err := lights.wait(t); err != nil {
+		t.Fatalf("pending SetLight failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.SetSpeed{Speed: 0}, protocol.Lights{On: false})
+	if preempted := engine.Stats().Preempted; preempted != 1 {
+		t.Errorf("'%d' commands preempted, expected '1'", preempted)
+	}
+}
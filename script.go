@@ -0,0 +1,561 @@
+package lionchief
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// Script is a show: a sequence of train actions parsed from the script format, see ParseScript.
+// A script can be run any number of times, on any number of trains.
+type Script struct {
+	Name         string
+	instructions []scriptInstruction
+}
+
+type scriptOp int
+
+const (
+	// run the step
+	scriptOpStep scriptOp = iota
+	// start of a repeat block, jumps past its end when the count is 0
+	scriptOpRepeat
+	// end of a repeat block, jumps back to the start of its body until the count runs out
+	scriptOpNext
+	// start of a random block, jumps to one of its branches
+	scriptOpChoose
+	// end of a random branch or a goto
+	scriptOpJump
+)
+
+// repeatForever is the count of a repeat block that only ends when the script is aborted
+const repeatForever = -1
+
+type scriptInstruction struct {
+	line int
+	op   scriptOp
+	step func(ctx context.Context, runner *ScriptRunner) error
+	// repeat count, or repeatForever
+	count int
+	// where scriptOpRepeat, scriptOpNext and scriptOpJump go
+	target int
+	// the branches of scriptOpChoose
+	targets []int
+}
+
+// LoadScript reads a script file, see ParseScript. A script without a name is named after its file.
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	script, err := ParseScript(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script '%s': %w", path, err)
+	}
+	if script.Name == "" {
+		script.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return script, nil
+}
+
+// ParseScript reads the script format: one step per line, '#' starts a comment.
+//
+//	name <text>                 name the show
+//	speed <n>                   change speed with the simulator's momentum
+//	stop                        brake to a stop
+//	emergency                   stop now
+//	forward, reverse            set the direction, stop first
+//	wait <duration>             e.g. '2s', '1m30s', '500ms' or plain seconds '2.5'
+//	horn <duration>|on|off      sound the horn for a while, or until 'horn off'
+//	bell <duration>|on|off      ring the bell for a while, or until 'bell off'
+//	signal <name>|"<pattern>"   a horn signal by name ('grade_crossing') or pattern ("-- . --")
+//	say <id>|<name>|random      speak a phrase and wait for it to finish
+//	lights on|off|toggle
+//	volume <sound> <n>          sound is master, horn, bell, speech or engine
+//	repeat <n>|forever          repeat the steps up to 'end'
+//	random                      pick one of the groups of steps separated by 'or', up to 'end'
+//	label <name>                mark a place to 'goto <name>'
+//
+// Errors name the line they were found on. A loop that could go round without running a step,
+// such as a 'goto' straight back to its 'label', is an error.
+func ParseScript(text string) (*Script, error) {
+	parser := scriptParser{
+		script: &Script{},
+		labels: map[string]int{},
+	}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	line := 1
+	for ; scanner.Scan(); line++ {
+		fields, err := scriptFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		err = parser.parse(line, fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return parser.finish()
+}
+
+// scriptBlock is a repeat or random block waiting for its 'end'
+type scriptBlock struct {
+	keyword string
+	line    int
+	start   int
+	// number of instructions in the current branch, or body
+	steps int
+	// jumps at the ends of random branches, to point past the block's end
+	exits []int
+}
+
+// scriptGoto is a goto waiting for the labels to all be known
+type scriptGoto struct {
+	line        int
+	instruction int
+	label       string
+}
+
+type scriptParser struct {
+	script *Script
+	blocks []*scriptBlock
+	labels map[string]int
+	gotos  []scriptGoto
+}
+
+func (a *scriptParser) add(instruction scriptInstruction) {
+	a.script.instructions = append(a.script.instructions, instruction)
+	if len(a.blocks) > 0 {
+		a.blocks[len(a.blocks)-1].steps++
+	}
+}
+
+func (a *scriptParser) addStep(line int, step func(ctx context.Context, runner *ScriptRunner) error) {
+	a.add(scriptInstruction{line: line, op: scriptOpStep, step: step})
+}
+
+func (a *scriptParser) parse(line int, fields []string) error {
+	keyword, args := strings.ToLower(fields[0]), fields[1:]
+	expect := func(usage string, count int) error {
+		if len(args) != count {
+			return fmt.Errorf("expected '%s'", usage)
+		}
+		return nil
+	}
+
+	switch keyword {
+	case "name":
+		if len(args) == 0 {
+			return fmt.Errorf("expected 'name <text>'")
+		}
+		a.script.Name = strings.Join(args, " ")
+
+	case "speed":
+		if err := expect("speed <n>", 1); err != nil {
+			return err
+		}
+		speed, err := strconv.Atoi(args[0])
+		if err != nil || speed < 0 || protocol.MaxSpeed < speed {
+			return fmt.Errorf("invalid speed '%s', must be a whole number between '0' and '%d'", args[0], protocol.MaxSpeed)
+		}
+		a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+			return runner.simulator.AdjustSpeedToContext(ctx, speed)
+		})
+
+	case "stop":
+		if err := expect("stop", 0); err != nil {
+			return err
+		}
+		a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+			return runner.simulator.Brake(ctx)
+		})
+
+	case "emergency":
+		if err := expect("emergency", 0); err != nil {
+			return err
+		}
+		a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+			return runner.simulator.EmergencyStop()
+		})
+
+	case "forward", "reverse":
+		if err := expect(keyword, 0); err != nil {
+			return err
+		}
+		reverse := keyword == "reverse"
+		a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+			engine := runner.simulator.engine
+			if engine.GetReverse() == reverse {
+				return nil
+			}
+			if engine.GetSpeed() > 0 {
+				err := runner.simulator.Brake(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			return engine.SetReverse(reverse)
+		})
+
+	case "wait":
+		if err := expect("wait <duration>", 1); err != nil {
+			return err
+		}
+		duration, err := parseScriptDuration(args[0])
+		if err != nil {
+			return err
+		}
+		a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+			return runner.wait(ctx, duration)
+		})
+
+	case "horn", "bell":
+		if err := expect(keyword+" <duration>|on|off", 1); err != nil {
+			return err
+		}
+		soundType := SoundType(SOUNDTYPE_HORN)
+		if keyword == "bell" {
+			soundType = SoundType(SOUNDTYPE_BELL)
+		}
+		switch strings.ToLower(args[0]) {
+		case "on":
+			a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+				return runner.holdSound(ctx, soundType)
+			})
+		case "off":
+			a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+				return runner.releaseSound(soundType)
+			})
+		default:
+			duration, err := parseScriptDuration(args[0])
+			if err != nil {
+				return err
+			}
+			a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+				effect, err := runner.simulator.startSound(ctx, soundType, duration)
+				if err != nil {
+					return err
+				}
+				err = effect.Wait()
+				if ctx.Err() != nil {
+					return context.Cause(ctx)
+				}
+				return err
+			})
+		}
+
+	case "signal":
+		if err := expect(`signal <name>|"<pattern>"`, 1); err != nil {
+			return err
+		}
+		name := args[0]
+		if strings.ContainsAny(name, "-. ") {
+			err := ValidateHornPattern(name)
+			if err != nil {
+				return err
+			}
+			a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+				return runner.simulator.SoundPattern(ctx, name)
+			})
+			break
+		}
+		if _, ok := LookupHornSignal(name); !ok {
+			return fmt.Errorf("unknown horn signal '%s'", name)
+		}
+		a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+			return runner.simulator.SoundNamedSignal(ctx, name)
+		})
+
+	case "say":
+		if err := expect("say <id>|<name>|random", 1); err != nil {
+			return err
+		}
+		phrase := args[0]
+		// names depend on the train's profile, so are looked up when the step runs, but an id
+		// has to fit the speech command
+		if id, err := strconv.Atoi(phrase); err == nil && (id < 0 || math.MaxUint8 < id) {
+			return fmt.Errorf("invalid phrase '%s', must be a whole number between '0' and '%d'", phrase, math.MaxUint8)
+		}
+		a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+			return runner.say(ctx, phrase)
+		})
+
+	case "lights":
+		if err := expect("lights on|off|toggle", 1); err != nil {
+			return err
+		}
+		switch strings.ToLower(args[0]) {
+		case "on", "off":
+			enabled := strings.ToLower(args[0]) == "on"
+			a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+				return runner.simulator.Lights(enabled)
+			})
+		case "toggle":
+			a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+				return runner.simulator.ToggleLights()
+			})
+		default:
+			return fmt.Errorf("invalid lights '%s', must be on, off or toggle", args[0])
+		}
+
+	case "volume":
+		if err := expect("volume <sound> <n>", 2); err != nil {
+			return err
+		}
+		sound := strings.ToLower(args[0])
+		if !isRegistryVolume(sound) {
+			return fmt.Errorf("invalid sound '%s', must be one of %s", args[0], strings.Join(registryVolumes, ", "))
+		}
+		volume, err := strconv.Atoi(args[1])
+		if err != nil || volume < 0 || maxVolume(sound) < volume {
+			return fmt.Errorf("invalid %s volume '%s', must be a whole number between '0' and '%d'", sound, args[1], maxVolume(sound))
+		}
+		a.addStep(line, func(ctx context.Context, runner *ScriptRunner) error {
+			return volumeSetters(runner.simulator.engine)[sound](volume)
+		})
+
+	case "repeat":
+		if err := expect("repeat <n>|forever", 1); err != nil {
+			return err
+		}
+		count := repeatForever
+		if !strings.EqualFold(args[0], "forever") {
+			value, err := strconv.Atoi(args[0])
+			if err != nil || value < 0 {
+				return fmt.Errorf("invalid repeat count '%s', must be a whole number or forever", args[0])
+			}
+			count = value
+		}
+		a.add(scriptInstruction{line: line, op: scriptOpRepeat, count: count})
+		a.blocks = append(a.blocks, &scriptBlock{keyword: keyword, line: line, start: len(a.script.instructions) - 1})
+
+	case "random":
+		if err := expect("random", 0); err != nil {
+			return err
+		}
+		start := len(a.script.instructions)
+		a.add(scriptInstruction{line: line, op: scriptOpChoose, targets: []int{start + 1}})
+		a.blocks = append(a.blocks, &scriptBlock{keyword: keyword, line: line, start: start})
+
+	case "or":
+		if err := expect("or", 0); err != nil {
+			return err
+		}
+		block := a.innermost()
+		if block == nil || block.keyword != "random" {
+			return fmt.Errorf("'or' outside of a random block")
+		}
+		if block.steps == 0 {
+			return fmt.Errorf("empty branch in the random block from line %d", block.line)
+		}
+		block.exits = append(block.exits, len(a.script.instructions))
+		a.script.instructions = append(a.script.instructions, scriptInstruction{line: line, op: scriptOpJump})
+		choose := &a.script.instructions[block.start]
+		choose.targets = append(choose.targets, len(a.script.instructions))
+		block.steps = 0
+
+	case "end":
+		if err := expect("end", 0); err != nil {
+			return err
+		}
+		block := a.innermost()
+		if block == nil {
+			return fmt.Errorf("'end' without a repeat or random block")
+		}
+		if block.steps == 0 {
+			return fmt.Errorf("empty %s block from line %d", block.keyword, block.line)
+		}
+		a.blocks = a.blocks[:len(a.blocks)-1]
+		if block.keyword == "repeat" {
+			a.script.instructions = append(a.script.instructions, scriptInstruction{line: line, op: scriptOpNext, target: block.start})
+			a.script.instructions[block.start].target = len(a.script.instructions)
+		} else {
+			for _, exit := range block.exits {
+				a.script.instructions[exit].target = len(a.script.instructions)
+			}
+		}
+		// the whole block counts as one step of the block around it
+		if len(a.blocks) > 0 {
+			a.blocks[len(a.blocks)-1].steps++
+		}
+
+	case "label":
+		if err := expect("label <name>", 1); err != nil {
+			return err
+		}
+		name := strings.ToLower(args[0])
+		if previous, ok := a.labels[name]; ok {
+			return fmt.Errorf("label '%s' already used on line %d", args[0], a.script.instructions[previous].line)
+		}
+		// a label is a step that does nothing, so it always has an instruction to point at
+		a.labels[name] = len(a.script.instructions)
+		a.add(scriptInstruction{line: line, op: scriptOpJump, target: len(a.script.instructions) + 1})
+
+	case "goto":
+		if err := expect("goto <label>", 1); err != nil {
+			return err
+		}
+		a.gotos = append(a.gotos, scriptGoto{line: line, instruction: len(a.script.instructions), label: strings.ToLower(args[0])})
+		a.add(scriptInstruction{line: line, op: scriptOpJump})
+
+	default:
+		return fmt.Errorf("unknown step '%s'", fields[0])
+	}
+	return nil
+}
+
+func (a *scriptParser) innermost() *scriptBlock {
+	if len(a.blocks) == 0 {
+		return nil
+	}
+	return a.blocks[len(a.blocks)-1]
+}
+
+func (a *scriptParser) finish() (*Script, error) {
+	if block := a.innermost(); block != nil {
+		return nil, fmt.Errorf("line %d: %s block is missing its 'end'", block.line, block.keyword)
+	}
+	for _, jump := range a.gotos {
+		target, ok := a.labels[jump.label]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown label '%s'", jump.line, jump.label)
+		}
+		a.script.instructions[jump.instruction].target = target
+	}
+	if len(a.script.instructions) == 0 {
+		return nil, fmt.Errorf("script has no steps")
+	}
+	if line, ok := a.script.stepFreeLoop(); ok {
+		return nil, fmt.Errorf("line %d: loops forever without a step", line)
+	}
+	return a.script, nil
+}
+
+// stepFreeLoop finds a way round the script that never runs a step, which would spin forever,
+// returning the line of an instruction on it
+func (a *Script) stepFreeLoop() (int, bool) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(a.instructions))
+	var visit func(current int) (int, bool)
+	visit = func(current int) (int, bool) {
+		if current >= len(a.instructions) || a.instructions[current].op == scriptOpStep {
+			return 0, false
+		}
+		switch marks[current] {
+		case visiting:
+			return a.instructions[current].line, true
+		case visited:
+			return 0, false
+		}
+		marks[current] = visiting
+		instruction := a.instructions[current]
+		var next []int
+		switch instruction.op {
+		case scriptOpRepeat:
+			next = []int{current + 1, instruction.target}
+		case scriptOpNext:
+			// a counted repeat runs out, only one repeating forever goes round for good
+			next = []int{current + 1}
+			if a.instructions[instruction.target].count == repeatForever {
+				next = []int{instruction.target + 1}
+			}
+		case scriptOpChoose:
+			next = instruction.targets
+		case scriptOpJump:
+			next = []int{instruction.target}
+		}
+		for _, target := range next {
+			if line, ok := visit(target); ok {
+				return line, true
+			}
+		}
+		marks[current] = visited
+		return 0, false
+	}
+	for start := range a.instructions {
+		if line, ok := visit(start); ok {
+			return line, true
+		}
+	}
+	return 0, false
+}
+
+// scriptFields splits a line on whitespace, keeping double quoted text together and dropping comments
+func scriptFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+	for _, symbol := range line {
+		switch {
+		case quoted && symbol == '"':
+			quoted = false
+		case quoted:
+			field.WriteRune(symbol)
+		case symbol == '"':
+			quoted, inField = true, true
+		case symbol == '#':
+			if inField {
+				fields = append(fields, field.String())
+			}
+			return fields, nil
+		case symbol == ' ' || symbol == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(symbol)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("missing closing '\"'")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// parseScriptDuration reads a Go duration, or a plain number of seconds
+func parseScriptDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, numberErr := strconv.ParseFloat(value, 64)
+		if numberErr != nil {
+			return 0, fmt.Errorf("invalid duration '%s', e.g. '2s', '500ms' or '1.5'", value)
+		}
+		duration = time.Duration(seconds * float64(time.Second))
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("invalid duration '%s', must not be negative", value)
+	}
+	return duration, nil
+}
+
+func mustParseScript(text string) *Script {
+	script, err := ParseScript(text)
+	must("parse built in script", err)
+	return script
+}
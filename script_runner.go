@@ -0,0 +1,377 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrScriptRunning = errors.New("script is already running")
+	ErrScriptAborted = errors.New("script aborted")
+)
+
+// ScriptState is where a ScriptRunner is with its script.
+type ScriptState int
+
+const (
+	SCRIPTSTATE_IDLE ScriptState = iota
+	SCRIPTSTATE_RUNNING
+	SCRIPTSTATE_PAUSED
+	// Ran to the end
+	SCRIPTSTATE_FINISHED
+	// Aborted, or its context was done
+	SCRIPTSTATE_ABORTED
+	// A step failed
+	SCRIPTSTATE_FAILED
+)
+
+func (a ScriptState) String() string {
+	switch a {
+	case SCRIPTSTATE_IDLE:
+		return "Idle"
+	case SCRIPTSTATE_RUNNING:
+		return "Running"
+	case SCRIPTSTATE_PAUSED:
+		return "Paused"
+	case SCRIPTSTATE_FINISHED:
+		return "Finished"
+	case SCRIPTSTATE_ABORTED:
+		return "Aborted"
+	case SCRIPTSTATE_FAILED:
+		return "Failed"
+	}
+	return "Unknown"
+}
+
+// ScriptRunner runs a script against a simulator in the background. Whichever way the script
+// ends, any horn or bell it turned on is turned off again. The train itself is left as it is,
+// Brake it after an abort to stop it.
+type ScriptRunner struct {
+	simulator *TrainSimulator
+	script    *Script
+
+	lock    sync.Mutex
+	state   ScriptState
+	line    int
+	cancel  context.CancelCauseFunc
+	done    chan struct{}
+	err     error
+	paused  bool
+	changed chan struct{}
+	// horn and bell turned on with 'on', until 'off'
+	held map[SoundType]*SoundEffect
+}
+
+func NewScriptRunner(simulator *TrainSimulator, script *Script) *ScriptRunner {
+	return &ScriptRunner{
+		simulator: simulator,
+		script:    script,
+		changed:   make(chan struct{}),
+		held:      map[SoundType]*SoundEffect{},
+	}
+}
+
+// RunScript runs script to the end, see ScriptRunner.
+func (a *TrainSimulator) RunScript(ctx context.Context, script *Script) error {
+	return NewScriptRunner(a, script).Run(ctx)
+}
+
+func (a *ScriptRunner) State() ScriptState {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.state
+}
+
+// Line is the script line of the step running, or last run.
+func (a *ScriptRunner) Line() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.line
+}
+
+// Run runs the script, returning once it is over.
+func (a *ScriptRunner) Run(ctx context.Context) error {
+	err := a.Start(ctx)
+	if err != nil {
+		return err
+	}
+	return a.Wait()
+}
+
+// Start runs the script without blocking. A runner can start again once its script is over.
+func (a *ScriptRunner) Start(ctx context.Context) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.state == SCRIPTSTATE_RUNNING || a.state == SCRIPTSTATE_PAUSED {
+		return ErrScriptRunning
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	a.cancel = cancel
+	a.done = make(chan struct{})
+	a.err = nil
+	a.line = 0
+	a.paused = false
+	a.setState(SCRIPTSTATE_RUNNING)
+	go a.run(ctx, a.done)
+	return nil
+}
+
+// Wait blocks until the script is over, returning why it ended early if it did.
+func (a *ScriptRunner) Wait() error {
+	a.lock.Lock()
+	done := a.done
+	a.lock.Unlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.err
+}
+
+// Pause holds the script before its next step. A wait is paused part way through, any other
+// step already under way (a speed change, a horn signal) is finished first.
+func (a *ScriptRunner) Pause() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.state != SCRIPTSTATE_RUNNING {
+		return
+	}
+	a.paused = true
+	a.setState(SCRIPTSTATE_PAUSED)
+}
+
+func (a *ScriptRunner) Resume() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.state != SCRIPTSTATE_PAUSED {
+		return
+	}
+	a.paused = false
+	a.setState(SCRIPTSTATE_RUNNING)
+}
+
+// Abort ends the script, cutting short the step under way, and waits for it to be over.
+func (a *ScriptRunner) Abort() {
+	a.lock.Lock()
+	cancel := a.cancel
+	a.lock.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel(ErrScriptAborted)
+	a.Wait()
+}
+
+// setState must be called holding the lock
+func (a *ScriptRunner) setState(state ScriptState) {
+	a.state = state
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+func (a *ScriptRunner) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	a.simulator.engine.logger.Println("RunScript", a.script.Name)
+	defer a.simulator.engine.logger.Println("RunScript-Done", a.script.Name)
+
+	err := a.execute(ctx)
+	aborted := ctx.Err() != nil
+	if aborted {
+		err = context.Cause(ctx)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.cancel(nil)
+	a.cancel = nil
+
+	// the effects stop with the script's context
+	for soundType, effect := range a.held {
+		releaseErr := effect.Wait()
+		if err == nil {
+			err = releaseErr
+		}
+		delete(a.held, soundType)
+	}
+
+	a.err = err
+	switch {
+	case err == nil:
+		a.setState(SCRIPTSTATE_FINISHED)
+	case aborted:
+		a.setState(SCRIPTSTATE_ABORTED)
+	default:
+		a.setState(SCRIPTSTATE_FAILED)
+	}
+}
+
+func (a *ScriptRunner) execute(ctx context.Context) error {
+	counters := map[int]int{}
+	instructions := a.script.instructions
+	for next := 0; next < len(instructions); {
+		err := a.whilePaused(ctx)
+		if err != nil {
+			return err
+		}
+
+		current := next
+		instruction := instructions[current]
+		next++
+		switch instruction.op {
+		case scriptOpStep:
+			a.lock.Lock()
+			a.line = instruction.line
+			a.lock.Unlock()
+			err = instruction.step(ctx, a)
+			if err != nil {
+				if ctx.Err() != nil {
+					return context.Cause(ctx)
+				}
+				return fmt.Errorf("line %d: %w", instruction.line, err)
+			}
+		case scriptOpRepeat:
+			counters[current] = instruction.count
+			if instruction.count == 0 {
+				next = instruction.target
+			}
+		case scriptOpNext:
+			start := instruction.target
+			if counters[start] != repeatForever {
+				counters[start]--
+				if counters[start] <= 0 {
+					break
+				}
+			}
+			next = start + 1
+		case scriptOpChoose:
+			next = instruction.targets[rand.Intn(len(instruction.targets))]
+		case scriptOpJump:
+			next = instruction.target
+		}
+	}
+	return nil
+}
+
+// whilePaused blocks while the script is paused
+func (a *ScriptRunner) whilePaused(ctx context.Context) error {
+	for {
+		a.lock.Lock()
+		paused, changed := a.paused, a.changed
+		a.lock.Unlock()
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if !paused {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		}
+	}
+}
+
+// wait waits on the simulator's clock, not counting any time spent paused
+func (a *ScriptRunner) wait(ctx context.Context, duration time.Duration) error {
+	clock := a.simulator.Clock()
+	remaining := duration
+	for remaining > 0 {
+		err := a.whilePaused(ctx)
+		if err != nil {
+			return err
+		}
+		a.lock.Lock()
+		changed := a.changed
+		paused := a.paused
+		a.lock.Unlock()
+		if paused {
+			continue
+		}
+
+		started := clock.Now()
+		timer := clock.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return context.Cause(ctx)
+		case <-timer.C():
+			return nil
+		case <-changed:
+			timer.Stop()
+			remaining -= clock.Now().Sub(started)
+		}
+	}
+	return nil
+}
+
+// holdSound turns a sound on until releaseSound or the script ends
+func (a *ScriptRunner) holdSound(ctx context.Context, soundType SoundType) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.held[soundType]; ok {
+		return nil
+	}
+	effect, err := a.simulator.startSound(ctx, soundType, untilStopped)
+	if err != nil {
+		return err
+	}
+	a.held[soundType] = effect
+	return nil
+}
+
+func (a *ScriptRunner) releaseSound(soundType SoundType) error {
+	a.lock.Lock()
+	effect, ok := a.held[soundType]
+	delete(a.held, soundType)
+	a.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	return effect.Stop()
+}
+
+// unknownPhraseLength is how long to let a phrase the profile has no length for play
+const unknownPhraseLength = 3 * time.Second
+
+// say speaks a phrase by id, name or at random, then waits as long as the profile says it takes
+func (a *ScriptRunner) say(ctx context.Context, name string) error {
+	profile := a.simulator.engine.Profile()
+	var phrase Phrase
+	var ok bool
+	switch id, err := strconv.Atoi(name); {
+	case err == nil:
+		phrase, ok = profile.Phrase(id)
+		if !ok {
+			// the profile may not list every phrase the train knows, so an id it lacks is sent
+			// as is, parsing already made sure it fits the speech command
+			phrase, ok = Phrase{ID: id}, true
+		}
+	case strings.EqualFold(name, "random"):
+		phrases := profile.RandomPhrases()
+		if len(phrases) > 0 {
+			phrase, ok = phrases[rand.Intn(len(phrases))], true
+		}
+	default:
+		phrase, ok = profile.PhraseByName(name)
+	}
+	if !ok {
+		return fmt.Errorf("engine profile '%s' has no phrase '%s'", profile.Name, name)
+	}
+
+	err := a.simulator.SpeakPhrase(SpeechPhrase(phrase.ID))
+	if err != nil {
+		return err
+	}
+	length := time.Duration(phrase.DurationMs) * time.Millisecond
+	if length == 0 {
+		length = unknownPhraseLength
+	}
+	return a.wait(ctx, length)
+}
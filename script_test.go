@@ -0,0 +1,59 @@
+package lionchief
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseScriptRanges(t *testing.T) {
+	for _, test := range []struct {
+		script string
+		err    string
+	}{
+		{"speed 31\nvolume master 7\nvolume horn 13", ""},
+		{"speed 0\nvolume engine 0", ""},
+		{"lights on\nspeed 32", "line 2: invalid speed '32'"},
+		{"speed -1", "line 1: invalid speed '-1'"},
+		{"speed 5\n\nvolume master 8", "line 3: invalid master volume '8'"},
+		{"volume bell 14", "line 1: invalid bell volume '14'"},
+		{"volume speech -1", "line 1: invalid speech volume '-1'"},
+		{"say 0\nsay 255\nsay random", ""},
+		{"say 256", "line 1: invalid phrase '256'"},
+		{"speed 2\nsay -1", "line 2: invalid phrase '-1'"},
+	} {
+		_, err := ParseScript(test.script)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("ParseScript(%q) failed: %v", test.script, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("ParseScript(%q) error = %v, expected one containing '%s'", test.script, err, test.err)
+		}
+	}
+}
+
+func TestParseScriptLoops(t *testing.T) {
+	for _, test := range []struct {
+		script string
+		err    string
+	}{
+		{"label a\nspeed 1\ngoto a", ""},
+		{"repeat 3\nlabel a\nend", ""},
+		{"repeat forever\nspeed 1\nend", ""},
+		{"label a\ngoto a", "line 1: loops forever without a step"},
+		{"speed 1\nlabel a\nlabel b\ngoto a", "line 2: loops forever without a step"},
+		{"goto b\nlabel a\nspeed 1\nlabel b\ngoto b", "line 4: loops forever without a step"},
+		{"label a\nrandom\nspeed 1\nor\nlabel b\nend\ngoto a", "line 1: loops forever without a step"},
+		{"repeat 2\nlabel a\ngoto a\nend", "line 2: loops forever without a step"},
+		{"repeat forever\nlabel a\nend", "line 2: loops forever without a step"},
+		// even a loop the random block only might take
+		{"label a\nrandom\nspeed 1\nor\ngoto a\nend", "line 1: loops forever without a step"},
+	} {
+		_, err := ParseScript(test.script)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("ParseScript(%q) failed: %v", test.script, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("ParseScript(%q) error = %v, expected one containing '%s'", test.script, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// ShutdownOptions controls how much Shutdown does before letting go of the train.
+type ShutdownOptions struct {
+	// Stop the train (as an emergency stop, ahead of anything still queued) before disconnecting
+	StopTrain bool
+	// How long to wait for the train to drop the link after the disconnect command before
+	// closing it from our side
+	LinkTimeout time.Duration
+}
+
+var DefaultShutdownOptions = ShutdownOptions{
+	StopTrain:   true,
+	LinkTimeout: 2 * time.Second,
+}
+
+// Shutdown lets go of the train cleanly: it stops the train if asked to, silences the horn and
+// bell, sends the protocol disconnect command and waits for the link to close, without trying
+// to reconnect. Commands the engine's profile does not support are skipped, and without the
+// disconnect command the link is closed from our side straight away. Steps that fail are
+// reported but do not stop the rest; ctx bounds the whole thing, though a command already
+// queued when it is done may still be sent. If ctx is done before the link has closed the
+// engine is left running, and Shutdown may be called again.
+func (a *TrainEngine) Shutdown(ctx context.Context, options ShutdownOptions) error {
+	a.logger.Println("Shutdown")
+	defer a.logger.Println("Shutdown-Done")
+	if !a.shuttingDown.CompareAndSwap(false, true) {
+		return ErrEngineClosed
+	}
+
+	var errs []error
+	step := func(err error) {
+		// running out of time is reported once, after the wait for the link
+		if err != nil && !errors.Is(err, ctx.Err()) {
+			errs = append(errs, err)
+		}
+	}
+	if options.StopTrain {
+		step(a.emergencyStopContext(ctx))
+	}
+	profile := a.Profile()
+	for _, command := range []struct {
+		capability Capability
+		cmd        protocol.Command
+	}{
+		{CAPABILITY_HORN, protocol.Horn{On: false}},
+		{CAPABILITY_BELL, protocol.Bell{On: false}},
+		{CAPABILITY_DISCONNECT, protocol.Disconnect{}},
+	} {
+		if profile.Supports(command.capability) {
+			step(a.sendRequestContext(ctx, &commandRequest{cmd: command.cmd, priority: commandPriority(command.cmd)}))
+		}
+	}
+
+	linkClosed := false
+	if profile.Supports(CAPABILITY_DISCONNECT) {
+		timer := time.NewTimer(options.LinkTimeout)
+		select {
+		case <-a.linkClosed:
+			linkClosed = true
+		case <-timer.C:
+			a.logger.Println("Train did not drop the link, closing it")
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	} else {
+		a.logger.Printf("Profile '%s' has no disconnect command, closing the link", profile.Name)
+	}
+	if ctx.Err() != nil && !linkClosed {
+		errs = append(errs, ctx.Err())
+		a.shuttingDown.Store(false)
+		return errors.Join(errs...)
+	}
+
+	a.cancel()
+	a.setConnectionState(CONNECTIONSTATE_DISCONNECTED, 0, nil)
+	if !linkClosed {
+		errs = append(errs, a.transport.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// ShutdownOnSignal shuts the engine down with DefaultShutdownOptions when the process receives
+// SIGINT or SIGTERM, so killing a controller no longer leaves the train running. Exiting is up
+// to the application: the returned channel delivers the result of the shutdown to wait on, and
+// is closed without one if listening stops first. A second signal cuts the shutdown short, and
+// once it is over signals are handled as they were before. The returned function stops
+// listening, waiting for a shutdown already under way.
+func (a *TrainEngine) ShutdownOnSignal() (<-chan error, func()) {
+	return shutdownOnSignal(a.Shutdown, a.logger.Printf)
+}
+
+func shutdownOnSignal(shutdown func(context.Context, ShutdownOptions) error, logf func(string, ...any)) (<-chan error, func()) {
+	signals := make(chan os.Signal, 2)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		defer close(done)
+		defer close(result)
+		defer signal.Stop(signals)
+		select {
+		case <-stop:
+			return
+		case received := <-signals:
+			logf("Received '%v', shutting down", received)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		go func() {
+			select {
+			case received := <-signals:
+				logf("Received '%v' again, giving up on a clean shutdown", received)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err := shutdown(ctx, DefaultShutdownOptions)
+		if err != nil {
+			logf("Shutdown failed: %v", err)
+		}
+		result <- err
+	}()
+
+	var once sync.Once
+	return result, func() {
+		once.Do(func() {
+			close(stop)
+		})
+		<-done
+	}
+}
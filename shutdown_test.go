@@ -0,0 +1,169 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func TestShutdown(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	err := engine.SetSpeed(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.Reset()
+
+	err = engine.Shutdown(context.Background(), DefaultShutdownOptions)
+	if err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.SetSpeed{Speed: 0}, protocol.Horn{On: false}, protocol.Bell{On: false}, protocol.Disconnect{})
+	if state := engine.ConnectionState(); state != CONNECTIONSTATE_DISCONNECTED {
+		t.Errorf("connection is '%v' after Shutdown, expected '%v'", state, CONNECTIONSTATE_DISCONNECTED)
+	}
+}
+
+func TestShutdownWithoutDisconnect(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	profile := *engine.Profile()
+	profile.Commands = []Capability{CAPABILITY_SPEED, CAPABILITY_HORN}
+	engine.SetProfile(&profile)
+
+	// nothing to wait for, the train is never told to hang up
+	start := time.Now()
+	err := engine.Shutdown(context.Background(), ShutdownOptions{StopTrain: true, LinkTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took '%v' waiting for a link that would never close", elapsed)
+	}
+	assertFrames(t, transport, protocol.SetSpeed{Speed: 0}, protocol.Horn{On: false})
+}
+
+func TestShutdownContext(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	holdCommands(engine)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := engine.Shutdown(ctx, ShutdownOptions{LinkTimeout: 5 * time.Second})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if count := strings.Count(err.Error(), context.DeadlineExceeded.Error()); count != 1 {
+		t.Errorf("Shutdown reported the deadline '%d' times: %v", count, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took '%v' with a 50ms context", elapsed)
+	}
+	assertFrames(t, transport)
+}
+
+func TestShutdownRetry(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	release := holdCommands(engine)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	options := ShutdownOptions{LinkTimeout: 5 * time.Second}
+	err := engine.Shutdown(ctx, options)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if state := engine.ConnectionState(); state != CONNECTIONSTATE_CONNECTED {
+		t.Fatalf("connection is '%v' after a Shutdown that ran out of time, expected '%v'", state, CONNECTIONSTATE_CONNECTED)
+	}
+
+	// the horn queued by the first attempt still goes out
+	release()
+	waitFor(t, "the queued horn to be written", func() bool { return pendingCount(engine) == 0 })
+	err = engine.Shutdown(context.Background(), options)
+	if err != nil {
+		t.Fatalf("second Shutdown failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: false}, protocol.Horn{On: false}, protocol.Bell{On: false}, protocol.Disconnect{})
+	if state := engine.ConnectionState(); state != CONNECTIONSTATE_DISCONNECTED {
+		t.Errorf("connection is '%v' after Shutdown, expected '%v'", state, CONNECTIONSTATE_DISCONNECTED)
+	}
+	if err := engine.Shutdown(context.Background(), options); !errors.Is(err, ErrEngineClosed) {
+		t.Errorf("Shutdown of a shut down engine returned %v, expected %v", err, ErrEngineClosed)
+	}
+}
+
+func TestShutdownWhileReconnecting(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	// keep the connection goroutine busy reconnecting for the whole shutdown
+	reconnecting := make(chan struct{})
+	stuck := make(chan struct{})
+	defer close(stuck)
+	engine.OnConnectionChange(func(change ConnectionChange) {
+		if change.To == CONNECTIONSTATE_RECONNECTING {
+			close(reconnecting)
+			<-stuck
+		}
+	})
+	transport.SetConnected(false)
+	<-reconnecting
+	transport.SetConnected(true)
+
+	start := time.Now()
+	err := engine.Shutdown(context.Background(), ShutdownOptions{LinkTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took '%v', the train hanging up went unnoticed", elapsed)
+	}
+	assertFrames(t, transport, protocol.Horn{On: false}, protocol.Bell{On: false}, protocol.Disconnect{})
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	shutdowns := make(chan ShutdownOptions, 1)
+	result, stop := shutdownOnSignal(func(ctx context.Context, options ShutdownOptions) error {
+		shutdowns <- options
+		return nil
+	}, t.Logf)
+	defer stop()
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = process.Signal(syscall.SIGTERM)
+	if err != nil {
+		t.Skipf("cannot signal ourselves: %v", err)
+	}
+
+	select {
+	case err, ok := <-result:
+		if !ok || err != nil {
+			t.Fatalf("shutdown on a signal returned '%v', '%v', expected a nil result", err, ok)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the shutdown")
+	}
+	if options := <-shutdowns; options != DefaultShutdownOptions {
+		t.Errorf("shut down with '%+v', expected '%+v'", options, DefaultShutdownOptions)
+	}
+}
+
+func TestShutdownOnSignalStop(t *testing.T) {
+	result, stop := shutdownOnSignal(func(ctx context.Context, options ShutdownOptions) error {
+		t.Error("shut down without a signal")
+		return nil
+	}, t.Logf)
+	stop()
+	stop()
+	if _, ok := <-result; ok {
+		t.Error("result delivered without a signal")
+	}
+}
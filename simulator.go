@@ -1,147 +1,176 @@
 package lionchief
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"math"
 	"math/rand"
-	"time"
+	"sync"
 
 	"tinygo.org/x/bluetooth"
 )
 
 type TrainSimulator struct {
 	address bluetooth.Address
+	opts    []Option
 	engine  *TrainEngine
+	// set when the train came from a registry, which then keeps its last known state
+	registry   *Registry
+	registered string
+
+	rampLock   sync.Mutex
+	momentum   Momentum
+	rampCancel context.CancelCauseFunc
+	rampDone   chan struct{}
+	clock      Clock
+
+	soundLock  sync.Mutex
+	hornTiming HornTiming
 }
 
 func NewSimulator(trainAddress bluetooth.Address) (*TrainSimulator, error) {
-	train, err := NewEngineDefaultBluetoothAdapter(trainAddress)
+	return NewSimulatorContext(context.Background(), trainAddress)
+}
+
+// NewSimulatorContext connects to the train like NewEngineContext, remembering opts for Reconnect.
+func NewSimulatorContext(ctx context.Context, trainAddress bluetooth.Address, opts ...Option) (*TrainSimulator, error) {
+	train, err := NewEngineContext(ctx, trainAddress, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	simulator := TrainSimulator{
-		address: trainAddress,
-		engine:  train,
+		address:    trainAddress,
+		opts:       opts,
+		engine:     train,
+		momentum:   DefaultMomentum,
+		clock:      train.Clock(),
+		hornTiming: DefaultHornTiming,
 	}
 
 	return &simulator, nil
 }
 
+// NewSimulatorWithEngine wraps an already constructed engine, e.g. one driven by a MemoryTransport.
+func NewSimulatorWithEngine(engine *TrainEngine) *TrainSimulator {
+	return &TrainSimulator{
+		engine:     engine,
+		momentum:   DefaultMomentum,
+		clock:      engine.Clock(),
+		hornTiming: DefaultHornTiming,
+	}
+}
+
 func (a *TrainSimulator) Disconnect() error {
+	a.saveState()
 	return a.engine.Disconnect()
 }
 
-func (a *TrainSimulator) Reconnect() error {
-	train, err := NewEngineDefaultBluetoothAdapter(a.address)
-	if err != nil {
-		return err
-	}
-	a.engine = train
-	return nil
+// Shutdown lets go of the train cleanly, see TrainEngine.Shutdown.
+func (a *TrainSimulator) Shutdown(ctx context.Context, options ShutdownOptions) error {
+	a.saveState()
+	return a.engine.Shutdown(ctx, options)
 }
 
-func (a *TrainSimulator) AdjustSpeedTo(speed int) error {
-	if speed < 0 || 31 < speed {
-		return fmt.Errorf("speed must be between 0 and 31")
-	}
-
-	initialSpeed := a.engine.GetSpeed()
-	var increment int
-	if initialSpeed == speed {
-		return fmt.Errorf("train is already at speed %d", speed)
-	} else if initialSpeed > speed {
-		increment = -1
-	} else {
-		increment = 1
-	}
-
-	currentSpeed := initialSpeed
-	for currentSpeed != speed {
-		newSpeed := currentSpeed + increment
-		newVolume := int(math.Ceil(float64(newSpeed) / 3))
-		err := a.engine.SetEngineVolume(newVolume)
-		if err != nil {
-			return err
-		}
+// ShutdownOnSignal shuts the train down on SIGINT or SIGTERM, see TrainEngine.ShutdownOnSignal.
+func (a *TrainSimulator) ShutdownOnSignal() (<-chan error, func()) {
+	return shutdownOnSignal(a.Shutdown, log.Printf)
+}
 
-		err = a.engine.SetSpeed(newSpeed)
-		if err != nil {
-			return err
-		}
+// Clock is what the simulator times its sounds and ramps by.
+func (a *TrainSimulator) Clock() Clock {
+	a.rampLock.Lock()
+	defer a.rampLock.Unlock()
+	return a.clock
+}
 
-		currentSpeed = newSpeed
-	}
-	return nil
+// SetClock times the simulator by clock instead of the engine's Clock, e.g. a VirtualClock.
+// A Momentum with its own Clock keeps using that for ramps.
+func (a *TrainSimulator) SetClock(clock Clock) {
+	a.rampLock.Lock()
+	defer a.rampLock.Unlock()
+	a.clock = clock
 }
 
-func (a *TrainSimulator) BeginTrainService() error {
-	err := a.engine.SetBell(true)
-	if err != nil {
-		return err
-	}
-	time.Sleep(1 * time.Second)
-	err = a.engine.SetBell(false)
-	if err != nil {
-		return err
+// saveState remembers the train's state in the registry it came from, if any
+func (a *TrainSimulator) saveState() {
+	if a.registry == nil {
+		return
 	}
-	time.Sleep(2 * time.Second)
-	err = a.AdjustSpeedTo(3)
+	err := a.registry.SaveState(a.registered, a.engine.Snapshot())
 	if err != nil {
-		return err
+		log.Printf("Saving state of '%s' failed: %v", a.registered, err)
 	}
-	return nil
 }
 
-func (a *TrainSimulator) EndTrainService() error {
-	err := a.engine.SetHorn(true)
-	if err != nil {
-		return err
-	}
-	time.Sleep(1 * time.Second)
-	err = a.engine.SetHorn(false)
+func (a *TrainSimulator) Reconnect() error {
+	train, err := NewEngineContext(context.Background(), a.address, a.opts...)
 	if err != nil {
 		return err
 	}
-	time.Sleep(1 * time.Second)
-	err = a.AdjustSpeedTo(0)
-	if err != nil {
-		return err
+	if a.registry != nil {
+		if registered, ok := a.registry.Lookup(a.registered); ok {
+			err = registered.configure(train)
+			if err != nil {
+				train.Disconnect()
+				return err
+			}
+		}
 	}
+	a.engine = train
 	return nil
 }
 
+// The built in shows, written in the script format, see ParseScript
+var (
+	beginTrainServiceScript = mustParseScript(`
+name Begin train service
+bell 1s
+signal proceed
+speed 3
+`)
+	endTrainServiceScript = mustParseScript(`
+name End train service
+horn 1s
+wait 1s
+speed 0
+`)
+	speelScript = mustParseScript(`
+name Speel
+say 4
+say 5
+say 6
+`)
+)
+
+func (a *TrainSimulator) BeginTrainService() error {
+	return a.RunScript(context.Background(), beginTrainServiceScript)
+}
+
+func (a *TrainSimulator) EndTrainService() error {
+	return a.RunScript(context.Background(), endTrainServiceScript)
+}
+
+// ReverseTrainService stops the train, signals the move (three shorts to back up, two longs to
+// go forward again) and brings it back up to speed the other way.
 func (a *TrainSimulator) ReverseTrainService() error {
-	err := a.engine.SetHorn(true)
-	if err != nil {
-		return err
-	}
-	time.Sleep(1 * time.Second)
-	err = a.engine.SetHorn(false)
+	originalSpeed := a.engine.GetSpeed()
+	err := a.AdjustSpeedTo(0)
 	if err != nil {
 		return err
 	}
-	time.Sleep(1 * time.Second)
 
-	err = a.engine.SetHorn(true)
-	if err != nil {
-		return err
-	}
-	time.Sleep(1 * time.Second)
-	err = a.engine.SetHorn(false)
-	if err != nil {
-		return err
+	reverse := !a.engine.GetReverse()
+	signal := HORNSIGNAL_PROCEED
+	if reverse {
+		signal = HORNSIGNAL_BACK_UP
 	}
-
-	time.Sleep(1 * time.Second)
-	originalSpeed := a.engine.GetSpeed()
-	err = a.AdjustSpeedTo(0)
+	err = a.soundStandardSignal(signal)
 	if err != nil {
 		return err
 	}
 
-	err = a.engine.SetReverse(!a.engine.GetReverse())
+	err = a.engine.SetReverse(reverse)
 	if err != nil {
 		return err
 	}
@@ -154,36 +183,28 @@ func (a *TrainSimulator) ReverseTrainService() error {
 	return nil
 }
 
-func (a *TrainSimulator) SoundHorn(length int) error {
-	err := a.engine.SetHorn(true)
-	if err != nil {
-		return err
-	}
-	time.Sleep(time.Second * time.Duration(length))
-	err = a.engine.SetHorn(false)
-	if err != nil {
-		return err
-	}
-	return nil
+// EnableWatchdog stops the train if Heartbeat is not called often enough, see TrainEngine.EnableWatchdog.
+func (a *TrainSimulator) EnableWatchdog(config WatchdogConfig) error {
+	return a.engine.EnableWatchdog(config)
 }
 
-func (a *TrainSimulator) SoundBell(length int) error {
-	err := a.engine.SetBell(true)
-	if err != nil {
-		return err
-	}
-	time.Sleep(time.Second * time.Duration(length))
-	err = a.engine.SetBell(false)
-	if err != nil {
-		return err
-	}
-	return nil
+func (a *TrainSimulator) Heartbeat() {
+	a.engine.Heartbeat()
+}
+
+// EmergencyStop stops the train now, abandoning any ramp in progress.
+func (a *TrainSimulator) EmergencyStop() error {
+	return a.engine.EmergencyStop()
 }
 
 func (a *TrainSimulator) Speak() error {
-	validPhrases := []int{SPEECHPHRASE_CALL_ME_PENNSYLVANIA_FLYER, SPEECHPHRASE_FASTEST_FREIGHT_YOU_CAN_HIRE, SPEECHPHRASE_HEY_THERE_WHAT_ARE_YOU_WAITING_FOR, SPEECHPHRASE_I_MAKE_STEAM_FROM_WATER_AND_FIRE, SPEECHPHRASE_PENNSYLVANIA_FLYER_IS_READY_TO_ROLL, SPEECHPHRASE_IM_FEELING_A_LITTLE_SQUEAKY_GIVE_ME_A_LITTLE_OIL}
+	profile := a.engine.Profile()
+	validPhrases := profile.RandomPhrases()
+	if len(validPhrases) == 0 {
+		return fmt.Errorf("engine profile '%s' has no phrases to pick from", profile.Name)
+	}
 	phrase := validPhrases[rand.Intn(len(validPhrases))]
-	return a.engine.SpeakPhrase(SpeechPhrase(phrase))
+	return a.engine.SpeakPhrase(SpeechPhrase(phrase.ID))
 }
 
 func (a *TrainSimulator) SpeakPhrase(phrase SpeechPhrase) error {
@@ -191,12 +212,7 @@ func (a *TrainSimulator) SpeakPhrase(phrase SpeechPhrase) error {
 }
 
 func (a *TrainSimulator) SpeakSpeel() error {
-
-	for i := 4; i < 7; i++ {
-		a.engine.SpeakPhrase(SpeechPhrase(i))
-		time.Sleep(3 * time.Second)
-	}
-	return nil
+	return a.RunScript(context.Background(), speelScript)
 }
 
 func (a *TrainSimulator) Lights(enabled bool) error {
@@ -206,8 +222,15 @@ func (a *TrainSimulator) Lights(enabled bool) error {
 	return err
 }
 
+// GetCurrentState returns a copy of the train state, changing it does not affect the train.
 func (a *TrainSimulator) GetCurrentState() *TrainState {
-	return a.engine.state
+	state := a.engine.Snapshot()
+	return &state
+}
+
+// Subscribe returns a channel of every change to the train, see TrainEngine.Subscribe.
+func (a *TrainSimulator) Subscribe() (<-chan Event, func()) {
+	return a.engine.Subscribe()
 }
 
 func (a *TrainSimulator) ToggleLights() error {
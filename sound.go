@@ -0,0 +1,157 @@
+package lionchief
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SoundEffect is a horn or bell sounding in the background. Whatever happens (it runs its
+// course, is stopped, its context is cancelled or a command fails) the sound is turned off
+// again once nothing else is holding it on.
+type SoundEffect struct {
+	Sound  SoundType
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Stop silences the effect and waits for the sound to be turned off.
+func (a *SoundEffect) Stop() error {
+	a.cancel()
+	return a.Wait()
+}
+
+// Cancel silences the effect without waiting.
+func (a *SoundEffect) Cancel() {
+	a.cancel()
+}
+
+// Wait blocks until the effect is over, returning any error turning the sound off.
+func (a *SoundEffect) Wait() error {
+	<-a.done
+	return a.err
+}
+
+// Done is closed once the effect is over.
+func (a *SoundEffect) Done() <-chan struct{} {
+	return a.done
+}
+
+// untilStopped is the length of an effect that sounds until it is stopped or cancelled
+const untilStopped time.Duration = -1
+
+// StartHorn sounds the horn for length without blocking.
+func (a *TrainSimulator) StartHorn(ctx context.Context, length time.Duration) (*SoundEffect, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("invalid horn length '%v', must not be negative", length)
+	}
+	return a.startSound(ctx, SOUNDTYPE_HORN, length)
+}
+
+// StartBell rings the bell for length without blocking.
+func (a *TrainSimulator) StartBell(ctx context.Context, length time.Duration) (*SoundEffect, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("invalid bell length '%v', must not be negative", length)
+	}
+	return a.startSound(ctx, SOUNDTYPE_BELL, length)
+}
+
+// HoldHorn sounds the horn until the effect is stopped or ctx is done, e.g. for a hold to honk button.
+func (a *TrainSimulator) HoldHorn(ctx context.Context) (*SoundEffect, error) {
+	return a.startSound(ctx, SOUNDTYPE_HORN, untilStopped)
+}
+
+// HoldBell rings the bell until the effect is stopped or ctx is done.
+func (a *TrainSimulator) HoldBell(ctx context.Context) (*SoundEffect, error) {
+	return a.startSound(ctx, SOUNDTYPE_BELL, untilStopped)
+}
+
+// SoundHorn sounds the horn for length seconds, see StartHorn for anything shorter.
+func (a *TrainSimulator) SoundHorn(length int) error {
+	effect, err := a.StartHorn(context.Background(), time.Duration(length)*time.Second)
+	if err != nil {
+		return err
+	}
+	return effect.Wait()
+}
+
+// SoundBell rings the bell for length seconds, see StartBell for anything shorter.
+func (a *TrainSimulator) SoundBell(length int) error {
+	effect, err := a.StartBell(context.Background(), time.Duration(length)*time.Second)
+	if err != nil {
+		return err
+	}
+	return effect.Wait()
+}
+
+func (a *TrainSimulator) startSound(ctx context.Context, soundType SoundType, length time.Duration) (*SoundEffect, error) {
+	err := a.engine.holdSound(soundType)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	effect := &SoundEffect{
+		Sound:  soundType,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(effect.done)
+		defer cancel()
+		if length != untilStopped {
+			timer := a.Clock().NewTimer(length)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C():
+			}
+		} else {
+			<-ctx.Done()
+		}
+		effect.err = a.engine.releaseSound(soundType)
+	}()
+	return effect, nil
+}
+
+// holdSound turns the sound on unless another effect already has. Overlapping effects of the
+// same sound share it, so one ending does not cut the other short.
+func (a *TrainEngine) holdSound(soundType SoundType) error {
+	a.soundLock.Lock()
+	defer a.soundLock.Unlock()
+	a.sounding[soundType]++
+	if a.sounding[soundType] > 1 {
+		return nil
+	}
+
+	err := a.setSound(soundType, true)
+	if err != nil {
+		a.sounding[soundType]--
+		// it may have gone out regardless, never risk leaving it on
+		if offErr := a.setSound(soundType, false); offErr != nil {
+			a.logger.Printf("Turning the %s off failed: %v", soundTypeName(soundType), offErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseSound lets go of a hold, turning the sound off once nothing holds it. A sound turned
+// on without a hold (e.g. by SetHorn) is simply turned off.
+func (a *TrainEngine) releaseSound(soundType SoundType) error {
+	a.soundLock.Lock()
+	defer a.soundLock.Unlock()
+	a.sounding[soundType] = max(a.sounding[soundType]-1, 0)
+	if a.sounding[soundType] > 0 {
+		return nil
+	}
+	return a.setSound(soundType, false)
+}
+
+func (a *TrainEngine) setSound(soundType SoundType, on bool) error {
+	if soundType == SOUNDTYPE_BELL {
+		return a.SetBell(on)
+	}
+	return a.SetHorn(on)
+}
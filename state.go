@@ -0,0 +1,219 @@
+package lionchief
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+var ErrStateVersion = errors.New("unsupported train state version")
+
+// TrainStateVersion is bumped whenever the JSON form of TrainState changes incompatibly
+const TrainStateVersion = 1
+
+// TrainState is everything the engine knows about the train. It serialises to JSON so it
+// can be saved as a scene and brought back later with TrainEngine.ApplyState.
+type TrainState struct {
+	Version      int             `json:"version"`
+	Speed        int             `json:"speed"`
+	Reverse      bool            `json:"reverse"`
+	Light        bool            `json:"light"`
+	Volume       int             `json:"volume"`
+	VolumeHorn   int             `json:"volume_horn"`
+	VolumeEngine int             `json:"volume_engine"`
+	VolumeBell   int             `json:"volume_bell"`
+	VolumeSpeech int             `json:"volume_speech"`
+	PitchHorn    SoundPitch      `json:"pitch_horn"`
+	PitchEngine  SoundPitch      `json:"pitch_engine"`
+	PitchBell    SoundPitch      `json:"pitch_bell"`
+	PitchSpeech  SoundPitch      `json:"pitch_speech"`
+	HornActive   bool            `json:"horn_active"`
+	BellActive   bool            `json:"bell_active"`
+	LastPhrase   *SpeechPhrase   `json:"last_phrase,omitempty"`
+	Connection   ConnectionState `json:"connection"`
+	LastCommand  time.Time       `json:"last_command"`
+}
+
+// Snapshot returns a copy of the state the engine has most recently set on the train.
+func (a *TrainEngine) Snapshot() TrainState {
+	a.stateLock.RLock()
+	state := a.state.clone()
+	a.stateLock.RUnlock()
+
+	state.Version = TrainStateVersion
+	state.Connection = a.ConnectionState()
+	return state
+}
+
+func (a TrainState) clone() TrainState {
+	if a.LastPhrase != nil {
+		phrase := *a.LastPhrase
+		a.LastPhrase = &phrase
+	}
+	return a
+}
+
+// VolumeOf is the volume of a single sound.
+func (a *TrainState) VolumeOf(soundType SoundType) int {
+	volume := a.volumeField(soundType)
+	if volume == nil {
+		return 0
+	}
+	return *volume
+}
+
+// PitchOf is the pitch of a single sound.
+func (a *TrainState) PitchOf(soundType SoundType) SoundPitch {
+	pitch := a.pitchField(soundType)
+	if pitch == nil {
+		return SoundPitch(SOUNDPITCH_NORMAL)
+	}
+	return *pitch
+}
+
+func (a *TrainState) volumeField(soundType SoundType) *int {
+	switch soundType {
+	case SOUNDTYPE_HORN:
+		return &a.VolumeHorn
+	case SOUNDTYPE_BELL:
+		return &a.VolumeBell
+	case SOUNDTYPE_SPEECH:
+		return &a.VolumeSpeech
+	case SOUNDTYPE_ENGINE:
+		return &a.VolumeEngine
+	}
+	return nil
+}
+
+func (a *TrainState) pitchField(soundType SoundType) *SoundPitch {
+	switch soundType {
+	case SOUNDTYPE_HORN:
+		return &a.PitchHorn
+	case SOUNDTYPE_BELL:
+		return &a.PitchBell
+	case SOUNDTYPE_SPEECH:
+		return &a.PitchSpeech
+	case SOUNDTYPE_ENGINE:
+		return &a.PitchEngine
+	}
+	return nil
+}
+
+// applyCommand folds the effect of a command into state, returning what changed
+func applyCommand(state *TrainState, cmd protocol.Command) []Event {
+	events := []Event{}
+	switch c := cmd.(type) {
+	case protocol.SetSpeed:
+		events = appendChange(events, EVENT_SPEED, 0, state.Speed, int(c.Speed))
+		state.Speed = int(c.Speed)
+	case protocol.SetDirection:
+		reverse := c.Direction == protocol.DirectionReverse
+		events = appendChange(events, EVENT_DIRECTION, 0, state.Reverse, reverse)
+		state.Reverse = reverse
+	case protocol.Lights:
+		events = appendChange(events, EVENT_LIGHTS, 0, state.Light, c.On)
+		state.Light = c.On
+	case protocol.Horn:
+		events = appendChange(events, EVENT_HORN, SOUNDTYPE_HORN, state.HornActive, c.On)
+		state.HornActive = c.On
+	case protocol.Bell:
+		events = appendChange(events, EVENT_BELL, SOUNDTYPE_BELL, state.BellActive, c.On)
+		state.BellActive = c.On
+	case protocol.Speak:
+		phrase := SpeechPhrase(c.Phrase)
+		var before any
+		if state.LastPhrase != nil {
+			before = *state.LastPhrase
+		}
+		// saying the same thing twice is still worth hearing about
+		events = append(events, Event{Type: EVENT_PHRASE, Sound: SOUNDTYPE_SPEECH, Before: before, After: phrase})
+		state.LastPhrase = &phrase
+	case protocol.MasterVolume:
+		events = appendChange(events, EVENT_VOLUME, 0, state.Volume, int(c.Level))
+		state.Volume = int(c.Level)
+	case protocol.SetSoundVolume:
+		volume := state.volumeField(SoundType(c.Type))
+		if volume != nil {
+			events = appendChange(events, EVENT_VOLUME, SoundType(c.Type), *volume, int(c.Level))
+			*volume = int(c.Level)
+		}
+	case protocol.SetSoundPitch:
+		pitch := state.pitchField(SoundType(c.Type))
+		if pitch != nil {
+			events = appendChange(events, EVENT_PITCH, SoundType(c.Type), *pitch, SoundPitch(byte(c.Pitch)))
+			*pitch = SoundPitch(byte(c.Pitch))
+		}
+	}
+	return events
+}
+
+func appendChange[T comparable](events []Event, eventType EventType, sound SoundType, before T, after T) []Event {
+	if before == after {
+		return events
+	}
+	return append(events, Event{Type: eventType, Sound: sound, Before: before, After: after})
+}
+
+// ApplyState brings the train to the target state, sending only the commands for what differs
+// from the current one. Connection and LastCommand are informational and ignored, as is
+// LastPhrase since speech is not something the train is left in. A moving train is stopped
+// before changing direction, then brought up to the target speed. The horn and bell are held
+// and released like any other sound effect, so overlapping effects are not cut short.
+func (a *TrainEngine) ApplyState(target TrainState) error {
+	a.logger.Println("ApplyState")
+	defer a.logger.Println("ApplyState-Done")
+	if target.Version > TrainStateVersion {
+		return fmt.Errorf("%w, got '%d' but only understand up to '%d'", ErrStateVersion, target.Version, TrainStateVersion)
+	}
+
+	current := a.Snapshot()
+	var steps []func() error
+	if current.Light != target.Light {
+		steps = append(steps, func() error { return a.SetLight(target.Light) })
+	}
+	if current.Volume != target.Volume {
+		steps = append(steps, func() error { return a.SetMainVolume(target.Volume) })
+	}
+	for _, soundType := range []SoundType{SOUNDTYPE_HORN, SOUNDTYPE_BELL, SOUNDTYPE_SPEECH, SOUNDTYPE_ENGINE} {
+		if current.VolumeOf(soundType) != target.VolumeOf(soundType) {
+			steps = append(steps, func() error { return a.setSoundVolume(soundType, target.VolumeOf(soundType)) })
+		}
+		if current.PitchOf(soundType) != target.PitchOf(soundType) {
+			steps = append(steps, func() error { return a.setSoundPitch(soundType, target.PitchOf(soundType)) })
+		}
+	}
+	if current.Reverse != target.Reverse {
+		if current.Speed != 0 {
+			steps = append(steps, func() error { return a.SetSpeed(0) })
+			current.Speed = 0
+		}
+		steps = append(steps, func() error { return a.SetReverse(target.Reverse) })
+	}
+	if current.Speed != target.Speed {
+		steps = append(steps, func() error { return a.SetSpeed(target.Speed) })
+	}
+	if current.HornActive != target.HornActive {
+		steps = append(steps, func() error { return a.applySound(SOUNDTYPE_HORN, target.HornActive) })
+	}
+	if current.BellActive != target.BellActive {
+		steps = append(steps, func() error { return a.applySound(SOUNDTYPE_BELL, target.BellActive) })
+	}
+
+	for _, step := range steps {
+		err := step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySound holds the sound on, or releases it, for a state being applied
+func (a *TrainEngine) applySound(soundType SoundType, on bool) error {
+	if on {
+		return a.holdSound(soundType)
+	}
+	return a.releaseSound(soundType)
+}
@@ -0,0 +1,140 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func TestApplyState(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		setup    func(engine *TrainEngine) error
+		change   func(target *TrainState)
+		expected []protocol.Command
+	}{
+		{
+			"unchanged",
+			nil,
+			func(target *TrainState) {},
+			nil,
+		},
+		{
+			"only what differs",
+			nil,
+			func(target *TrainState) {
+				target.Light = false
+				target.Volume = 3
+				target.PitchEngine = SoundPitch(SOUNDPITCH_LOW)
+				target.Speed = 5
+				target.BellActive = true
+			},
+			[]protocol.Command{
+				protocol.Lights{On: false},
+				protocol.MasterVolume{Level: 3},
+				protocol.SetSoundPitch{Type: protocol.SoundEngine, Pitch: protocol.PitchLow},
+				protocol.SetSpeed{Speed: 5},
+				protocol.Bell{On: true},
+			},
+		},
+		{
+			"stops before changing direction",
+			func(a *TrainEngine) error { return a.SetSpeed(4) },
+			func(target *TrainState) {
+				target.Reverse = true
+				target.Speed = 2
+			},
+			[]protocol.Command{
+				protocol.SetSpeed{Speed: 0},
+				protocol.SetDirection{Direction: protocol.DirectionReverse},
+				protocol.SetSpeed{Speed: 2},
+			},
+		},
+		{
+			"ignores connection and phrase",
+			nil,
+			func(target *TrainState) {
+				phrase := SpeechPhrase(3)
+				target.LastPhrase = &phrase
+				target.Connection = CONNECTIONSTATE_DISCONNECTED
+			},
+			nil,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			engine, transport := newTestEngine(t)
+			if test.setup != nil {
+				err := test.setup(engine)
+				if err != nil {
+					t.Fatal(err)
+				}
+				transport.Reset()
+			}
+
+			target := engine.Snapshot()
+			test.change(&target)
+			err := engine.ApplyState(target)
+			if err != nil {
+				t.Fatalf("ApplyState failed: %v", err)
+			}
+			assertFrames(t, transport, test.expected...)
+		})
+	}
+}
+
+func TestApplyStateVersion(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	target := engine.Snapshot()
+	target.Version = TrainStateVersion + 1
+	target.Speed = 5
+
+	err := engine.ApplyState(target)
+	if !errors.Is(err, ErrStateVersion) {
+		t.Fatalf("ApplyState of a newer version returned %v, expected %v", err, ErrStateVersion)
+	}
+	assertFrames(t, transport)
+}
+
+func TestApplyStateSharesTheHorn(t *testing.T) {
+	simulator, transport := newTestSimulator(t)
+	engine := simulator.engine
+
+	on := engine.Snapshot()
+	on.HornActive = true
+	err := engine.ApplyState(on)
+	if err != nil {
+		t.Fatalf("ApplyState failed: %v", err)
+	}
+	horn, err := simulator.HoldHorn(context.Background())
+	if err != nil {
+		t.Fatalf("HoldHorn failed: %v", err)
+	}
+
+	// the scene lets go of the horn, the held horn keeps it sounding
+	off := engine.Snapshot()
+	off.HornActive = false
+	err = engine.ApplyState(off)
+	if err != nil {
+		t.Fatalf("ApplyState failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true})
+
+	err = horn.Stop()
+	if err != nil {
+		t.Fatalf("stopping the horn failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true}, protocol.Horn{On: false})
+
+	// a horn turned on without a hold is simply turned off
+	err = engine.SetHorn(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.ApplyState(off)
+	if err != nil {
+		t.Fatalf("ApplyState failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true}, protocol.Horn{On: false}, protocol.Horn{On: true}, protocol.Horn{On: false})
+}
@@ -0,0 +1,307 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Transport moves framed commands to a locomotive and notifications back from it.
+// The engine only ever talks to the train through a Transport, so anything that can
+// carry bytes (a real BLE link, a recording fake, a network bridge) can drive it.
+type Transport interface {
+	// WriteFrame writes one complete frame (leading 0x00, payload and checksum).
+	WriteFrame(frame []byte) error
+	// Subscribe registers a handler that is called for every notification frame
+	// the train sends.
+	Subscribe(handler func(frame []byte)) error
+	// ConnectionEvents reports link changes, true when the link comes up and
+	// false when it drops.
+	ConnectionEvents() <-chan bool
+	// Reconnect re-establishes a dropped link, giving up when ctx is done.
+	Reconnect(ctx context.Context) error
+	// Close drops the link and stops any further events.
+	Close() error
+}
+
+// BluetoothTransport is the Transport for a LionChief locomotive reached over BLE
+// through a tinygo bluetooth adapter.
+type BluetoothTransport struct {
+	adapter             *bluetooth.Adapter
+	address             bluetooth.Address
+	connectionParams    bluetooth.ConnectionParams
+	disconnected        *chan bluetooth.Device
+	events              chan bool
+	lock                sync.RWMutex
+	device              *bluetooth.Device
+	writeService        *bluetooth.DeviceService
+	writeCharacteristic *bluetooth.DeviceCharacteristic
+	handlers            []func(frame []byte)
+	logger              *log.Logger
+	// closed stops the connection events once the transport is closed
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewBluetoothTransport(trainAddress bluetooth.Address, adapter *bluetooth.Adapter) (*BluetoothTransport, error) {
+	return NewBluetoothTransportContext(context.Background(), trainAddress, WithAdapter(adapter))
+}
+
+// NewBluetoothTransportContext enables the adapter and connects to the train, giving up when ctx is done.
+// Only the adapter, connection params and logger options apply to a transport.
+func NewBluetoothTransportContext(ctx context.Context, trainAddress bluetooth.Address, opts ...Option) (*BluetoothTransport, error) {
+	config := newEngineConfig(opts)
+	adapter := config.adapter
+
+	transport := BluetoothTransport{
+		adapter:          adapter,
+		address:          trainAddress,
+		connectionParams: config.connectionParams,
+		events:           make(chan bool, 1),
+		logger:           config.logger,
+		closed:           make(chan struct{}),
+	}
+
+	transport.logger.Println("Enabling Adapter")
+	err := waitContext(ctx, adapter.Enable, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAdapterUnavailable, err)
+	}
+
+	disconnected := make(chan bluetooth.Device)
+	transport.disconnected = &disconnected
+	adapter.SetConnectHandler(func(device bluetooth.Device, connected bool) {
+		transport.logger.Println("Connection handler")
+		if !connected {
+			select {
+			case disconnected <- device:
+			case <-transport.closed:
+			}
+		}
+	})
+
+	transport.logger.Printf("Connecting to '%v'", trainAddress.MAC.String())
+	err = waitContext(ctx, transport.connect, func() {
+		// we gave up waiting but the connection went through anyway, let it go
+		transport.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// fire off a new process to wait an listen for a disconnect, the engine decides what to do about it
+	go func(transport *BluetoothTransport) {
+		for {
+			select {
+			case <-transport.closed:
+				return
+			case device := <-disconnected:
+				transport.logger.Println("Device disconnected.")
+				if device.Address == transport.address {
+					transport.logger.Println("Train disconnected.")
+					transport.publish(false)
+				}
+			}
+		}
+	}(&transport)
+
+	return &transport, nil
+}
+
+// waitContext runs action, which cannot be interrupted, and stops waiting for it once ctx is done.
+// If the abandoned action later succeeds abandoned is called to clean up after it.
+func waitContext(ctx context.Context, action func() error, abandoned func()) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- action()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if abandoned != nil {
+			go func() {
+				if <-done == nil {
+					abandoned()
+				}
+			}()
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %w", ErrConnectTimeout, ctx.Err())
+		}
+		return ctx.Err()
+	}
+}
+
+// connect connects to the train, finds the write characteristic and enables notifications,
+// only swapping the new link in once all of that has worked
+func (a *BluetoothTransport) connect() error {
+	device, err := a.adapter.Connect(a.address, a.connectionParams)
+	if err != nil {
+		return err
+	}
+
+	// never leave a half set up link behind, the next reconnect makes a new one
+	fail := func(err error) error {
+		if disconnectErr := device.Disconnect(); disconnectErr != nil {
+			a.logger.Printf("Dropping the half set up link failed: %v", disconnectErr)
+		}
+		return err
+	}
+
+	devicesServices, err := device.DiscoverServices([]bluetooth.UUID{ReadWriteService})
+	if err != nil {
+		return fail(err)
+	}
+
+	a.logger.Printf("Found '%v' services", len(devicesServices))
+	if len(devicesServices) < 1 {
+		return fail(ErrServiceNotFound)
+	}
+
+	a.logger.Println("Discovering Characteristics")
+	characteristics, err := devicesServices[0].DiscoverCharacteristics([]bluetooth.UUID{WriteCharacteristic})
+	if err != nil {
+		return fail(err)
+	}
+
+	a.logger.Printf("Found '%v' characteristics", len(characteristics))
+	if len(characteristics) < 1 {
+		return fail(fmt.Errorf("%w: write characteristic", ErrCharacteristicNotFound))
+	}
+
+	a.lock.RLock()
+	handlers := a.handlers
+	a.lock.RUnlock()
+
+	// notifications do not survive a reconnect, so enable them again on the new link
+	for _, handler := range handlers {
+		err = enableNotifications(&devicesServices[0], handler)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	a.lock.Lock()
+	a.device = &device
+	a.writeService = &devicesServices[0]
+	a.writeCharacteristic = &characteristics[0]
+	a.lock.Unlock()
+	return nil
+}
+
+func (a *BluetoothTransport) Reconnect(ctx context.Context) error {
+	return waitContext(ctx, a.connect, nil)
+}
+
+// publish hands a connection change to whoever is listening, without ever
+// blocking the bluetooth callbacks on a slow reader.
+func (a *BluetoothTransport) publish(connected bool) {
+	select {
+	case a.events <- connected:
+	default:
+		a.logger.Printf("Dropping connection event '%v', nobody is listening", connected)
+	}
+}
+
+func (a *BluetoothTransport) WriteFrame(frame []byte) error {
+	a.lock.RLock()
+	writeCharacteristic := a.writeCharacteristic
+	a.lock.RUnlock()
+
+	written, err := writeCharacteristic.WriteWithoutResponse(frame)
+	if err != nil {
+		return err
+	}
+
+	if written != len(frame) {
+		return fmt.Errorf("writing command only wrote '%v' bytes of '%v'", written, len(frame))
+	}
+	return nil
+}
+
+func (a *BluetoothTransport) Subscribe(handler func(frame []byte)) error {
+	a.lock.RLock()
+	writeService := a.writeService
+	a.lock.RUnlock()
+
+	err := enableNotifications(writeService, handler)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	a.handlers = append(a.handlers, handler)
+	a.lock.Unlock()
+	return nil
+}
+
+func enableNotifications(writeService *bluetooth.DeviceService, handler func(frame []byte)) error {
+	characteristics, err := writeService.DiscoverCharacteristics([]bluetooth.UUID{ReadCharateristic})
+	if err != nil {
+		return err
+	}
+
+	if len(characteristics) < 1 {
+		return fmt.Errorf("%w: read characteristic", ErrCharacteristicNotFound)
+	}
+
+	return characteristics[0].EnableNotifications(func(buf []byte) {
+		// the buffer is only valid for the duration of the callback
+		frame := make([]byte, len(buf))
+		copy(frame, buf)
+		handler(frame)
+	})
+}
+
+func (a *BluetoothTransport) ReadCharacteristic(service bluetooth.UUID, characteristic bluetooth.UUID) ([]byte, error) {
+	a.lock.RLock()
+	device := a.device
+	a.lock.RUnlock()
+
+	services, err := device.DiscoverServices([]bluetooth.UUID{service})
+	if err != nil {
+		return nil, err
+	}
+	if len(services) < 1 {
+		return nil, fmt.Errorf("service '%v' not found", service.String())
+	}
+
+	characteristics, err := services[0].DiscoverCharacteristics([]bluetooth.UUID{characteristic})
+	if err != nil {
+		return nil, err
+	}
+	if len(characteristics) < 1 {
+		return nil, fmt.Errorf("%w: '%v'", ErrCharacteristicNotFound, characteristic.String())
+	}
+
+	// 512 bytes is the largest attribute value GATT allows
+	buf := make([]byte, 512)
+	read, err := characteristics[0].Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:read], nil
+}
+
+func (a *BluetoothTransport) ConnectionEvents() <-chan bool {
+	return a.events
+}
+
+func (a *BluetoothTransport) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+	})
+	a.lock.RLock()
+	device := a.device
+	a.lock.RUnlock()
+	if device == nil {
+		return nil
+	}
+	return device.Disconnect()
+}
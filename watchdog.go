@@ -0,0 +1,255 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+// WatchdogAction is how the watchdog brings the train to a stop.
+type WatchdogAction int
+
+const (
+	// Cut straight to speed 0, as an emergency stop
+	WATCHDOGACTION_CUT WatchdogAction = iota
+	// Slow down one speed step at a time
+	WATCHDOGACTION_RAMP
+)
+
+func (a WatchdogAction) String() string {
+	switch a {
+	case WATCHDOGACTION_CUT:
+		return "Cut"
+	case WATCHDOGACTION_RAMP:
+		return "Ramp"
+	}
+	return "Unknown"
+}
+
+// WatchdogConfig sets up the watchdog. Once enabled the application has to call Heartbeat at
+// least every Interval, otherwise the train is stopped and Alert is sounded. The watchdog stays
+// tripped until the next Heartbeat, which arms it again. While it is tripped every speed and
+// direction change fails with ErrEmergencyStop. Disabling the watchdog, or shutting the engine
+// down, cuts the ramp down and the alert short.
+type WatchdogConfig struct {
+	Interval time.Duration
+	Action   WatchdogAction
+	// Time between speed steps when ramping
+	RampStep time.Duration
+	// Sounded once the train has stopped, nil for none. ctx is done once the watchdog is
+	// disabled or the engine shut down.
+	Alert func(ctx context.Context, engine *TrainEngine) error
+}
+
+var DefaultWatchdogConfig = WatchdogConfig{
+	Interval: 5 * time.Second,
+	Action:   WATCHDOGACTION_RAMP,
+	RampStep: 100 * time.Millisecond,
+	Alert:    HornAlert(300*time.Millisecond, 200*time.Millisecond, 300*time.Millisecond, 200*time.Millisecond, 300*time.Millisecond),
+}
+
+// HornAlert sounds the horn for the given durations, alternating on and off, timed by the
+// engine's Clock. It shares the horn with any other effects sounding it, and stops early once
+// ctx is done.
+func HornAlert(pattern ...time.Duration) func(context.Context, *TrainEngine) error {
+	return func(ctx context.Context, engine *TrainEngine) error {
+		holding := false
+		// never leave it blaring
+		release := func(err error) error {
+			if holding {
+				return errors.Join(err, engine.releaseSound(SOUNDTYPE_HORN))
+			}
+			return err
+		}
+		for i, length := range pattern {
+			var err error
+			if i%2 == 0 {
+				err = engine.holdSound(SOUNDTYPE_HORN)
+				holding = err == nil
+			} else {
+				err = engine.releaseSound(SOUNDTYPE_HORN)
+				holding = false
+			}
+			if err != nil {
+				return err
+			}
+			err = waitClock(ctx, engine.Clock(), length)
+			if err != nil {
+				return release(err)
+			}
+		}
+		return release(nil)
+	}
+}
+
+// waitClock waits d on clock, giving up once ctx is done
+func waitClock(ctx context.Context, clock Clock, d time.Duration) error {
+	timer := clock.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+type watchdog struct {
+	config WatchdogConfig
+	beat   chan struct{}
+	stop   chan struct{}
+}
+
+// EnableWatchdog starts the watchdog, replacing any running one.
+func (a *TrainEngine) EnableWatchdog(config WatchdogConfig) error {
+	if config.Interval <= 0 {
+		return fmt.Errorf("invalid watchdog interval '%v', must be positive", config.Interval)
+	}
+
+	dog := &watchdog{
+		config: config,
+		beat:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	a.watchdogLock.Lock()
+	previous := a.watchdog
+	a.watchdog = dog
+	a.watchdogLock.Unlock()
+
+	if previous != nil {
+		close(previous.stop)
+	}
+	go a.runWatchdog(dog)
+	return nil
+}
+
+func (a *TrainEngine) DisableWatchdog() {
+	a.watchdogLock.Lock()
+	dog := a.watchdog
+	a.watchdog = nil
+	a.watchdogLock.Unlock()
+
+	if dog != nil {
+		close(dog.stop)
+	}
+}
+
+// Heartbeat tells the watchdog the application is still in control, letting the train move
+// again if it had tripped. It does nothing when the watchdog is not enabled.
+func (a *TrainEngine) Heartbeat() {
+	a.watchdogLock.Lock()
+	dog := a.watchdog
+	a.watchdogLock.Unlock()
+
+	if dog == nil {
+		return
+	}
+	select {
+	case dog.beat <- struct{}{}:
+	default:
+	}
+}
+
+func (a *TrainEngine) runWatchdog(dog *watchdog) {
+	// disabling the watchdog or shutting down cuts a trip short
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-dog.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	timer := a.clock.NewTimer(dog.config.Interval)
+	defer func() {
+		timer.Stop()
+	}()
+	tripped := false
+	defer func() {
+		// a disabled watchdog must not hold the train up
+		if tripped {
+			a.scheduler.resume()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-dog.beat:
+			if tripped {
+				a.logger.Println("Heartbeat is back, the train may move again")
+				a.scheduler.resume()
+				tripped = false
+			}
+			timer.Stop()
+			timer = a.clock.NewTimer(dog.config.Interval)
+		case <-timer.C():
+			a.logger.Printf("No heartbeat for '%v', stopping the train", dog.config.Interval)
+			tripped = true
+			a.tripWatchdog(ctx, dog.config)
+		}
+	}
+}
+
+// tripWatchdog stops the train, sounds the alert and tells subscribers about it
+func (a *TrainEngine) tripWatchdog(ctx context.Context, config WatchdogConfig) {
+	speed := a.GetSpeed()
+	// ramps in progress give up, and no new ones start until the next heartbeat
+	flushed := a.scheduler.halt()
+	if flushed > 0 {
+		a.logger.Printf("Watchdog dropped '%d' pending motion commands", flushed)
+	}
+
+	var err error
+	if config.Action == WATCHDOGACTION_RAMP {
+		err = a.rampToStop(ctx, config.RampStep)
+	}
+	if config.Action != WATCHDOGACTION_RAMP || err != nil {
+		err = a.EmergencyStop()
+	}
+	if err != nil {
+		a.logger.Printf("Watchdog could not stop the train: %v", err)
+	}
+
+	a.publish(Event{Type: EVENT_WATCHDOG, Before: speed, After: a.GetSpeed(), Time: time.Now()})
+
+	if config.Alert != nil {
+		err = config.Alert(ctx, a)
+		if err != nil {
+			a.logger.Printf("Watchdog alert failed: %v", err)
+		}
+	}
+}
+
+// rampToStop slows the train a step at a time, giving up if anything else stops it first.
+// The caller stops the train outright if ctx is done first.
+func (a *TrainEngine) rampToStop(ctx context.Context, step time.Duration) error {
+	emergencyStops := a.EmergencyStops()
+	for speed := a.GetSpeed() - 1; speed >= 0; speed-- {
+		err := a.sendRequestContext(ctx, &commandRequest{
+			cmd:      protocol.SetSpeed{Speed: uint8(speed)},
+			priority: PRIORITY_MOTION,
+			guarded:  true,
+			stops:    emergencyStops,
+			watchdog: true,
+		})
+		if errors.Is(err, ErrEmergencyStop) || errors.Is(err, ErrPreempted) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if speed > 0 {
+			err = waitClock(ctx, a.clock, step)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
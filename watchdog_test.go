@@ -0,0 +1,183 @@
+package lionchief
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jasper-186/lionchief/protocol"
+)
+
+func watchdogHalted(engine *TrainEngine) bool {
+	engine.scheduler.lock.Lock()
+	defer engine.scheduler.lock.Unlock()
+	return engine.scheduler.halted
+}
+
+func TestWatchdogStopsRamp(t *testing.T) {
+	simulator, _ := newTestSimulator(t)
+	engine := simulator.engine
+	// a step every 10ms, the ramp up takes a good deal longer than the watchdog interval
+	err := simulator.SetMomentum(Momentum{Acceleration: 100, Deceleration: 100, BrakeRate: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := engine.Subscribe()
+	defer unsubscribe()
+
+	err = engine.EnableWatchdog(WatchdogConfig{Interval: 50 * time.Millisecond, Action: WATCHDOGACTION_RAMP, RampStep: time.Millisecond})
+	if err != nil {
+		t.Fatalf("EnableWatchdog failed: %v", err)
+	}
+	defer engine.DisableWatchdog()
+
+	err = simulator.AdjustSpeedTo(20)
+	if !errors.Is(err, ErrEmergencyStop) {
+		t.Fatalf("ramp running into the watchdog returned %v, expected %v", err, ErrEmergencyStop)
+	}
+	timeout := time.After(5 * time.Second)
+	for tripped := false; !tripped; {
+		select {
+		case event := <-events:
+			tripped = event.Type == EVENT_WATCHDOG
+		case <-timeout:
+			t.Fatal("timed out waiting for the watchdog to trip")
+		}
+	}
+	if speed := engine.GetSpeed(); speed != 0 {
+		t.Fatalf("speed is '%d' once the watchdog has tripped, expected '0'", speed)
+	}
+
+	// nothing moves the train until the application is back in control
+	err = simulator.AdjustSpeedTo(5)
+	if !errors.Is(err, ErrEmergencyStop) {
+		t.Fatalf("ramp while the watchdog is tripped returned %v, expected %v", err, ErrEmergencyStop)
+	}
+	if speed := engine.GetSpeed(); speed != 0 {
+		t.Fatalf("speed is '%d' after a ramp while the watchdog is tripped, expected '0'", speed)
+	}
+
+	engine.Heartbeat()
+	waitFor(t, "the heartbeat to resume motion", func() bool { return !watchdogHalted(engine) })
+	err = engine.SetSpeedUnlessStopped(2, engine.EmergencyStops())
+	if err != nil {
+		t.Fatalf("SetSpeedUnlessStopped after a heartbeat failed: %v", err)
+	}
+}
+
+func TestDisabledWatchdogResumesMotion(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	err := engine.EnableWatchdog(WatchdogConfig{Interval: time.Millisecond, Action: WATCHDOGACTION_CUT})
+	if err != nil {
+		t.Fatalf("EnableWatchdog failed: %v", err)
+	}
+	waitFor(t, "the watchdog to trip", func() bool { return watchdogHalted(engine) })
+
+	engine.DisableWatchdog()
+	waitFor(t, "the watchdog to let go", func() bool { return !watchdogHalted(engine) })
+	err = engine.SetSpeedUnlessStopped(3, engine.EmergencyStops())
+	if err != nil {
+		t.Fatalf("SetSpeedUnlessStopped once the watchdog is disabled failed: %v", err)
+	}
+}
+
+func TestHornAlertSharesTheHorn(t *testing.T) {
+	simulator, transport := newTestSimulator(t)
+	horn, err := simulator.HoldHorn(context.Background())
+	if err != nil {
+		t.Fatalf("HoldHorn failed: %v", err)
+	}
+
+	// the alert must not cut off a horn something else is sounding
+	err = HornAlert(time.Millisecond, time.Millisecond, time.Millisecond)(context.Background(), simulator.engine)
+	if err != nil {
+		t.Fatalf("HornAlert failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true})
+
+	err = horn.Stop()
+	if err != nil {
+		t.Fatalf("stopping the horn failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Horn{On: true}, protocol.Horn{On: false})
+}
+
+func TestTrippedWatchdogRefusesMotion(t *testing.T) {
+	engine, transport := newTestEngine(t)
+	err := engine.EnableWatchdog(WatchdogConfig{Interval: time.Millisecond, Action: WATCHDOGACTION_CUT})
+	if err != nil {
+		t.Fatalf("EnableWatchdog failed: %v", err)
+	}
+	defer engine.DisableWatchdog()
+	// the stop goes out once the watchdog has halted the train
+	waitFor(t, "the watchdog to stop the train", func() bool { return watchdogHalted(engine) && len(transport.Frames()) == 1 })
+	transport.Reset()
+
+	for name, call := range map[string]func() error{
+		"SetSpeed":   func() error { return engine.SetSpeed(3) },
+		"SetReverse": func() error { return engine.SetReverse(true) },
+	} {
+		if err := call(); !errors.Is(err, ErrEmergencyStop) {
+			t.Errorf("%s while the watchdog is tripped returned %v, expected %v", name, err, ErrEmergencyStop)
+		}
+	}
+	// only motion is held up
+	err = engine.SetLight(false)
+	if err != nil {
+		t.Fatalf("SetLight while the watchdog is tripped failed: %v", err)
+	}
+	assertFrames(t, transport, protocol.Lights{On: false})
+}
+
+func TestDisableWatchdogCutsTripShort(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		config   WatchdogConfig
+		expected []protocol.Command
+	}{
+		{
+			"ramp",
+			WatchdogConfig{Interval: time.Second, Action: WATCHDOGACTION_RAMP, RampStep: time.Hour},
+			// the ramp gives up after its first step, so the train is stopped outright
+			[]protocol.Command{protocol.SetSpeed{Speed: 4}, protocol.SetSpeed{Speed: 0}},
+		},
+		{
+			"alert",
+			WatchdogConfig{Interval: time.Second, Action: WATCHDOGACTION_CUT, Alert: HornAlert(time.Hour)},
+			[]protocol.Command{protocol.SetSpeed{Speed: 0}, protocol.Horn{On: true}, protocol.Horn{On: false}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewVirtualClock(time.Unix(0, 0))
+			engine, transport := newTestEngine(t, WithClock(clock))
+			err := engine.SetSpeed(5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transport.Reset()
+
+			err = engine.EnableWatchdog(test.config)
+			if err != nil {
+				t.Fatalf("EnableWatchdog failed: %v", err)
+			}
+			clock.BlockUntil(1)
+			clock.Advance(time.Second)
+			// the ramp step or the alert is now waiting on the clock
+			clock.BlockUntil(1)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				engine.DisableWatchdog()
+				waitFor(t, "the watchdog to let go", func() bool { return !watchdogHalted(engine) })
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("disabling the watchdog did not cut the trip short")
+			}
+			assertFrames(t, transport, test.expected...)
+		})
+	}
+}